@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// abortCheckInterval is how often abort rules are evaluated, against the
+// requests which completed since the last check
+const abortCheckInterval = time.Second
+
+// abortRule stops a load test early when its condition holds continuously for
+// duration
+type abortRule struct {
+	condition
+	duration time.Duration
+	rule     string
+}
+
+// parseAbortRule parses a rule of the form "CONDITION[:DURATION]", such as
+// "error_rate>50%:30s". Without a duration, the rule aborts as soon as the
+// condition holds.
+func parseAbortRule(s string) (abortRule, error) {
+	r := abortRule{rule: s}
+	cond := s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		d, err := time.ParseDuration(s[i+1:])
+		if err != nil {
+			return abortRule{}, fmt.Errorf("invalid abort rule %q: %q is not a duration", s, s[i+1:])
+		}
+		cond, r.duration = s[:i], d
+	}
+
+	c, err := parseCondition(cond)
+	if err != nil {
+		return abortRule{}, err
+	}
+	r.condition = c
+	return r, nil
+}
+
+// abortError is returned when a load test is stopped by an abort rule
+type abortError struct {
+	rule   string // the rule which aborted the load test
+	reason string
+}
+
+func (e abortError) Error() string {
+	return "load test aborted: " + e.reason
+}
+
+// abortMonitor checks the abort rules of a load test against the requests
+// which complete each second, from the difference between snapshots of
+// their statistics, so that memory doesn't grow with the request rate. It is
+// safe for concurrent use.
+type abortMonitor struct {
+	rules []abortRule
+	total stats         // of every request
+	last  statsSnapshot // of total, at the last check
+	since []time.Time   // when each rule's condition started holding
+}
+
+// newAbortMonitor returns a monitor for rules
+func newAbortMonitor(rules []abortRule) *abortMonitor {
+	return &abortMonitor{rules: rules, since: make([]time.Time, len(rules))}
+}
+
+// observe adds the result of a request to the monitor
+func (m *abortMonitor) observe(r result) {
+	m.total.record(r)
+}
+
+// run checks the rules every abortCheckInterval, sending an abortError once
+// a rule's condition has held for its duration. It returns after sending an
+// abortError, or when stop is closed.
+func (m *abortMonitor) run(abort chan<- abortError, stop <-chan struct{}) {
+	ticker := time.NewTicker(abortCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if e, ok := m.check(now); ok {
+				abort <- e
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check evaluates the rules against the requests since the last check,
+// returning an abortError and true if the load test should be aborted.
+// Periods in which no requests completed neither satisfy nor break a rule's
+// condition. It must only be called by run.
+func (m *abortMonitor) check(now time.Time) (abortError, bool) {
+	snap := m.total.snapshot()
+	recent := snap.sub(m.last)
+	m.last = snap
+	if recent.counts.sent() == 0 {
+		return abortError{}, false
+	}
+
+	for i, r := range m.rules {
+		v := r.value(recent.counts, recent.latencies)
+		if !r.holds(v) {
+			m.since[i] = time.Time{}
+			continue
+		}
+		if m.since[i].IsZero() {
+			m.since[i] = now.Add(-abortCheckInterval)
+		}
+		if now.Sub(m.since[i]) >= r.duration {
+			return abortError{rule: r.rule, reason: fmt.Sprintf("%s was %s, matching %q", r.metric, r.format(v), r.rule)}, true
+		}
+	}
+	return abortError{}, false
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// Factors by which the adaptive rate controller changes the rate
+const (
+	adaptiveBackoff = 0.5 // multiplied by the rate when p99 is too high
+	adaptiveRampUp  = 0.1 // fraction of the target rate added when p99 recovers
+)
+
+// rateChange is a change in the request rate made by the rate controller
+type rateChange struct {
+	at     time.Duration // since the load test started
+	rate   int
+	p99    time.Duration
+	target int // the new target rate, if the target was changed rather than the rate adjusted
+}
+
+// rateController adjusts the request rate of a load test based on the
+// observed p99 latency. When p99 exceeds the threshold, the rate backs off
+// multiplicatively, and when it recovers the rate ramps back up towards the
+// target. The p99 of each interval is found from the difference between
+// snapshots of the statistics of every request. It is safe for concurrent
+// use.
+type rateController struct {
+	threshold time.Duration
+	min       int
+	total     stats // of every request
+
+	mu       sync.Mutex
+	target   int
+	last     statsSnapshot // of total, at the last adjustment
+	rate     int
+	timeline []rateChange
+}
+
+// newRateController returns a controller which starts at, and never exceeds,
+// the target rate
+func newRateController(threshold time.Duration, target, min int) *rateController {
+	return &rateController{
+		threshold: threshold,
+		target:    target,
+		min:       min,
+		rate:      target,
+		timeline:  []rateChange{{rate: target}},
+	}
+}
+
+// observe adds the result of a request to the controller
+func (c *rateController) observe(r result) {
+	c.total.record(r)
+}
+
+// current returns the current request rate
+func (c *rateController) current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate
+}
+
+// run adjusts the rate every interval until stop is closed
+func (c *rateController) run(start time.Time, interval time.Duration, logger *xlog.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.adjust(now.Sub(start), logger)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// setTarget changes the target rate, lowering the current rate to it if
+// necessary
+func (c *rateController) setTarget(target int, elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.target = target
+	if c.rate > target {
+		c.rate = target
+	}
+	c.timeline = append(c.timeline, rateChange{at: elapsed, rate: c.rate, target: target})
+}
+
+// adjust changes the rate based on the p99 latency of the requests since the
+// last adjustment. The rate is unchanged if no requests completed.
+func (c *rateController) adjust(elapsed time.Duration, logger *xlog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snap := c.total.snapshot()
+	recent := snap.sub(c.last)
+	c.last = snap
+	if recent.counts.sent() == 0 {
+		return
+	}
+	p99 := recent.latencies.percentile(99)
+
+	rate := c.rate
+	if p99 > c.threshold {
+		rate = int(float64(rate) * adaptiveBackoff)
+		if rate < c.min {
+			rate = c.min
+		}
+	} else {
+		rate += int(float64(c.target)*adaptiveRampUp + 0.5)
+		if rate > c.target {
+			rate = c.target
+		}
+	}
+	if rate == c.rate {
+		return
+	}
+
+	logger.Infof("p99 was %v, changing rate from %d to %d requests per second", p99.Round(time.Microsecond), c.rate, rate)
+	c.rate = rate
+	c.timeline = append(c.timeline, rateChange{at: elapsed, rate: rate, p99: p99})
+}
+
+// logTimeline logs each change in the rate
+func (c *rateController) logTimeline(logger *xlog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	logger.Infof("Rate timeline:")
+	for _, change := range c.timeline {
+		switch {
+		case change.at == 0:
+			logger.Infof("  %8v %d requests per second", change.at, change.rate)
+		case change.target > 0:
+			logger.Infof("  %8v %d requests per second (target changed to %d)", change.at.Round(time.Second), change.rate, change.target)
+		default:
+			logger.Infof("  %8v %d requests per second (p99 was %v)", change.at.Round(time.Second), change.rate, change.p99.Round(time.Microsecond))
+		}
+	}
+}
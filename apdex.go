@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// apdexZone is how satisfied a user would be with a request, for scoring
+// Apdex
+type apdexZone int
+
+const (
+	zoneNone       apdexZone = iota // not scored, as --apdex-t isn't set
+	zoneSatisfied                   // ok, within the target time
+	zoneTolerating                  // ok, within the frustrated threshold
+	zoneFrustrated                  // slower than that, or failed
+)
+
+// apdexThresholds are the latencies which divide requests into Apdex zones
+type apdexThresholds struct {
+	satisfied  time.Duration // the target time T, or 0 if Apdex isn't scored
+	frustrated time.Duration // above which an ok request is frustrated
+}
+
+// newApdexThresholds returns the thresholds for the target time t, with
+// requests frustrated above frustrated, or 4t if that is zero
+func newApdexThresholds(t, frustrated time.Duration) (apdexThresholds, error) {
+	if t == 0 {
+		if frustrated != 0 {
+			return apdexThresholds{}, errors.New("--apdex-frustrated requires --apdex-t")
+		}
+		return apdexThresholds{}, nil
+	}
+	if t < 0 {
+		return apdexThresholds{}, errors.New("--apdex-t must be positive")
+	}
+	if frustrated == 0 {
+		frustrated = 4 * t
+	}
+	if frustrated < t {
+		return apdexThresholds{}, errors.New("--apdex-frustrated must not be below --apdex-t")
+	}
+	return apdexThresholds{satisfied: t, frustrated: frustrated}, nil
+}
+
+// zone returns the Apdex zone of r. Failed requests are always frustrated.
+func (a apdexThresholds) zone(r result) apdexZone {
+	switch latency := r.timings[phaseTotal]; {
+	case a.satisfied == 0:
+		return zoneNone
+	case !r.ok || latency > a.frustrated:
+		return zoneFrustrated
+	case latency > a.satisfied:
+		return zoneTolerating
+	default:
+		return zoneSatisfied
+	}
+}
+
+// apdexScore returns the Apdex score of the requests in c, between 0 and 1,
+// or false if none were scored
+func apdexScore(c counts) (float64, bool) {
+	scored := c.satisfied + c.tolerating + c.frustrated
+	if scored == 0 {
+		return 0, false
+	}
+	return (float64(c.satisfied) + float64(c.tolerating)/2) / float64(scored), true
+}
+
+// logApdex logs the Apdex score of the load test, and of each tag if there
+// are several
+func (lt *loadTest) logApdex(logger *xlog.Logger) {
+	a := lt.cfg.apdex
+	logger.Infof("Apdex T %v: satisfied up to %v, tolerating up to %v, frustrated above that or failed", a.satisfied, a.satisfied, a.frustrated)
+	log := func(prefix string, c counts) {
+		if score, ok := apdexScore(c); ok {
+			logger.Infof("%sApdex %.2f: %d satisfied, %d tolerating, %d frustrated", prefix, score, c.satisfied, c.tolerating, c.frustrated)
+		}
+	}
+	log("", lt.stats.all.counts())
+	if len(lt.stats.tags) > 1 {
+		for _, tag := range lt.stats.tags {
+			log(tag+": ", lt.stats.byTag[tag].counts())
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// awsMetadataTimeout bounds each request for credentials to the container
+	// or instance metadata endpoints, so that the chain moves on quickly when
+	// not running on AWS
+	awsMetadataTimeout = 2 * time.Second
+
+	awsContainerHost    = "http://169.254.170.2"
+	awsInstanceHost     = "http://169.254.169.254"
+	awsInstanceRoles    = "/latest/meta-data/iam/security-credentials/"
+	awsInstanceToken    = "/latest/api/token"
+	awsInstanceTokenTTL = "21600" // seconds the instance metadata token lasts
+)
+
+// awsCredentials are the credentials requests are signed with
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	expires         time.Time // zero if they don't expire
+}
+
+// awsCredentialSource is one of the places credentials are looked for, in the
+// order of the AWS SDKs' default chain. fetch returns false if the source
+// isn't configured, so the next should be tried.
+type awsCredentialSource struct {
+	name  string
+	fetch func() (awsCredentials, bool, error)
+}
+
+var awsCredentialChain = []awsCredentialSource{
+	{"environment", awsEnvCredentials},
+	{"shared credentials file", awsSharedCredentials},
+	{"container", awsContainerCredentials},
+	{"instance metadata", awsInstanceCredentials},
+}
+
+// findAWSCredentials returns the credentials from the first source in the
+// default chain which is configured, along with the source's name.
+// Credentials from the single sign-on and credential_process settings of the
+// AWS CLI are not supported.
+func findAWSCredentials() (awsCredentials, string, error) {
+	for _, s := range awsCredentialChain {
+		creds, ok, err := s.fetch()
+		if err != nil {
+			return awsCredentials{}, "", fmt.Errorf("unable to get AWS credentials from the %s: %w", s.name, err)
+		}
+		if ok {
+			return creds, s.name, nil
+		}
+	}
+	return awsCredentials{}, "", errors.New("no AWS credentials found in the environment, shared credentials file, container or instance metadata")
+}
+
+// awsEnvCredentials reads credentials from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+func awsEnvCredentials() (awsCredentials, bool, error) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" && secret == "" {
+		return awsCredentials{}, false, nil
+	}
+	if id == "" || secret == "" {
+		return awsCredentials{}, false, errors.New("both AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return awsCredentials{accessKeyID: id, secretAccessKey: secret, sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, true, nil
+}
+
+// awsSharedCredentials reads the credentials of the AWS_PROFILE profile, or
+// the default, from AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials
+func awsSharedCredentials() (awsCredentials, bool, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return awsCredentials{}, false, nil
+	}
+	if err != nil {
+		return awsCredentials{}, false, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		case section == profile:
+			if i := strings.Index(line, "="); i >= 0 {
+				values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, false, err
+	}
+	if values["aws_access_key_id"] == "" {
+		return awsCredentials{}, false, nil
+	}
+	if values["aws_secret_access_key"] == "" {
+		return awsCredentials{}, false, fmt.Errorf("profile %s in %s has no aws_secret_access_key", profile, path)
+	}
+	return awsCredentials{
+		accessKeyID:     values["aws_access_key_id"],
+		secretAccessKey: values["aws_secret_access_key"],
+		sessionToken:    values["aws_session_token"],
+	}, true, nil
+}
+
+// awsContainerCredentials fetches the credentials of an ECS task or EKS pod
+// from the endpoint in AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or
+// AWS_CONTAINER_CREDENTIALS_FULL_URI
+func awsContainerCredentials() (awsCredentials, bool, error) {
+	url := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		url = awsContainerHost + relative
+	}
+	if url == "" {
+		return awsCredentials{}, false, nil
+	}
+
+	header := http.Header{}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		header.Set("Authorization", token)
+	}
+	creds, err := fetchAWSCredentials(url, header)
+	return creds, err == nil, err
+}
+
+// awsInstanceCredentials fetches the credentials of an EC2 instance's role
+// from the instance metadata service, using IMDSv2. It is skipped if
+// AWS_EC2_METADATA_DISABLED is true, or if the service can't be reached.
+func awsInstanceCredentials() (awsCredentials, bool, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return awsCredentials{}, false, nil
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, awsInstanceHost+awsInstanceToken, nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", awsInstanceTokenTTL)
+	token, err := awsMetadata(req)
+	if err != nil {
+		// Not running on EC2
+		return awsCredentials{}, false, nil
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+
+	req, _ = http.NewRequest(http.MethodGet, awsInstanceHost+awsInstanceRoles, nil)
+	req.Header = header
+	roles, err := awsMetadata(req)
+	if err != nil {
+		return awsCredentials{}, false, fmt.Errorf("unable to find the instance's role: %w", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return awsCredentials{}, false, nil
+	}
+	creds, err := fetchAWSCredentials(awsInstanceHost+awsInstanceRoles+role, header)
+	return creds, err == nil, err
+}
+
+// fetchAWSCredentials fetches credentials in the JSON format served by the
+// container and instance metadata endpoints
+func fetchAWSCredentials(url string, header http.Header) (awsCredentials, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header = header
+	body, err := awsMetadata(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	var resp struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return awsCredentials{}, fmt.Errorf("invalid credentials from %s: %w", url, err)
+	}
+	if resp.AccessKeyID == "" || resp.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("incomplete credentials from %s", url)
+	}
+	return awsCredentials{accessKeyID: resp.AccessKeyID, secretAccessKey: resp.SecretAccessKey, sessionToken: resp.Token, expires: resp.Expiration}, nil
+}
+
+// awsMetadata sends req to a metadata endpoint, returning the body of a 200
+// response
+func awsMetadata(req *http.Request) (string, error) {
+	client := http.Client{Timeout: awsMetadataTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	return string(body), nil
+}
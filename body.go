@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// applyBodyFlags returns targets with the body given by --json, --form or
+// --graphql-query, if any, along with any --form fields which must be sent
+// as a multipart/form-data body streamed with each request. Only one of
+// these flags may be given, and not for targets which already have a body.
+func applyBodyFlags(targets []target) ([]target, []formField, error) {
+	fields, err := parseFormFields(form)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	given := 0
+	for _, set := range []bool{jsonBody != "", len(fields) > 0, graphqlQuery != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, nil, errors.New("only one of --json, --form and --graphql-query may be given")
+	}
+	if graphqlVars != "" && graphqlQuery == "" {
+		return nil, nil, errors.New("--graphql-vars requires --graphql-query")
+	}
+
+	switch {
+	case jsonBody != "":
+		if !json.Valid([]byte(jsonBody)) {
+			return nil, nil, errors.New("--json is not valid JSON")
+		}
+		targets, err = setBodies(targets, []byte(jsonBody), "application/json", "--json")
+	case len(fields) > 0 && !hasFiles(fields):
+		targets, err = setBodies(targets, []byte(encodeForm(fields)), "application/x-www-form-urlencoded", "--form")
+		fields = nil
+	case len(fields) > 0:
+		targets, err = setBodies(targets, nil, "", "--form")
+	case graphqlQuery != "":
+		body, readErr := readGraphQLBody(graphqlQuery, graphqlVars)
+		if readErr != nil {
+			return nil, nil, fmt.Errorf("unable to read the GraphQL query: %w", readErr)
+		}
+		targets, err = setBodies(targets, body, "application/json", "--graphql-query")
+	}
+	return targets, fields, err
+}
+
+// setBodies returns a copy of targets which send body with the given
+// Content-Type, as POST requests unless they have another method. If body
+// is nil, only the method is set. flag names the flag that gave the body.
+func setBodies(targets []target, body []byte, contentType, flag string) ([]target, error) {
+	result := make([]target, len(targets))
+	for i, t := range targets {
+		if len(t.body) > 0 {
+			return nil, fmt.Errorf("target %s has a body, so can't also be sent the body from %s", t.tag, flag)
+		}
+		if t.method == "" {
+			t.method = http.MethodPost
+		}
+		if body != nil {
+			h := http.Header{}
+			for key, vals := range t.headers {
+				h[key] = vals
+			}
+			h.Set("Content-Type", contentType)
+			t.headers, t.body = h, body
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// encodeForm returns fields, none of which are files, encoded as an
+// application/x-www-form-urlencoded body
+func encodeForm(fields []formField) string {
+	values := url.Values{}
+	for _, f := range fields {
+		values.Add(f.name, f.value)
+	}
+	return values.Encode()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xfxdev/xlog"
+)
+
+const (
+	// cacheBustDefault is the query parameter given a unique value when
+	// --cache-bust is set without one
+	cacheBustDefault = "_slt"
+
+	// maxValidators is the most URLs whose validators are kept for
+	// --revalidate, so that URLs which vary every request can't use up
+	// memory
+	maxValidators = 10000
+)
+
+// cacheMode is how requests are made to interact with any caches in front of
+// the targets
+type cacheMode struct {
+	bust       bool   // give each request a unique value, so it misses
+	only       bool   // send identical requests, so all but the first hit
+	revalidate bool   // make requests conditional on earlier responses
+	header     string // to give the unique value in, if not a query parameter
+	param      string // query parameter to give the unique value in
+}
+
+// parseCacheMode parses the --cache-bust, --cache-only and --revalidate flags.
+// bust is a query parameter NAME or a header "header:NAME", or empty if not
+// set.
+func parseCacheMode(bust string, only, revalidate bool) (cacheMode, error) {
+	if bust == "" {
+		return cacheMode{only: only, revalidate: revalidate}, nil
+	}
+	if only {
+		return cacheMode{}, errors.New("--cache-bust and --cache-only can't be used together")
+	}
+	if revalidate {
+		return cacheMode{}, errors.New("--cache-bust and --revalidate can't be used together, as busted requests have nothing to revalidate")
+	}
+	m := cacheMode{bust: true, param: bust}
+	if strings.HasPrefix(bust, "header:") {
+		m.header, m.param = http.CanonicalHeaderKey(strings.TrimPrefix(bust, "header:")), ""
+		if m.header == "" {
+			return cacheMode{}, fmt.Errorf("invalid --cache-bust %q: expected a header name after header:", bust)
+		}
+	}
+	return m, nil
+}
+
+// isDefault returns true if requests are sent without regard to caches
+func (m cacheMode) isDefault() bool {
+	return !m.bust && !m.only && !m.revalidate
+}
+
+// String returns the name of the mode, as recorded in reports. --cache-only
+// and --revalidate together are "only+revalidate".
+func (m cacheMode) String() string {
+	switch {
+	case m.bust:
+		return "bust"
+	case m.only && m.revalidate:
+		return "only+revalidate"
+	case m.only:
+		return "only"
+	case m.revalidate:
+		return "revalidate"
+	default:
+		return "default"
+	}
+}
+
+// describe returns a description of the mode for the logs
+func (m cacheMode) describe() string {
+	const revalidate = "revalidate, with requests conditional on the ETag and Last-Modified of earlier responses"
+	switch {
+	case m.bust && m.header != "":
+		return fmt.Sprintf("bust, with a unique %s header in each request", m.header)
+	case m.bust:
+		return fmt.Sprintf("bust, with a unique %s query parameter in each request", m.param)
+	case m.only && m.revalidate:
+		return "only, with identical requests to measure cache hits, and " + revalidate
+	case m.only:
+		return "only, with identical requests to measure cache hits"
+	case m.revalidate:
+		return revalidate
+	default:
+		return "default"
+	}
+}
+
+// checkCacheOnly returns an error if cfg varies its requests, so they can't
+// all be served from a cache as --cache-only needs
+func checkCacheOnly(cfg config) error {
+	var varies []string
+	if len(cfg.randomParams) > 0 {
+		varies = append(varies, "--query-random")
+	}
+	if len(cfg.headerRotations) > 0 {
+		varies = append(varies, "--header-rotate")
+	}
+	if len(cfg.userAgents) > 1 {
+		varies = append(varies, "--user-agent-file")
+	}
+	if cfg.requestIDHeader != "" {
+		varies = append(varies, "--request-id-header")
+	}
+	if cfg.digestAuth.user != "" {
+		varies = append(varies, "--digest-auth")
+	}
+	if cfg.awsSigV4.region != "" {
+		varies = append(varies, "--aws-sigv4")
+	}
+	if hasFiles(cfg.form) {
+		varies = append(varies, "--form files")
+	}
+	for _, t := range cfg.targets {
+		if len(t.params) > 0 {
+			varies = append(varies, "--mix placeholders")
+			break
+		}
+	}
+	if len(varies) > 0 {
+		return fmt.Errorf("--cache-only can't be used with %s, which vary each request", strings.Join(varies, ", "))
+	}
+	return nil
+}
+
+// bustCache gives req a value which no other request has, in the query
+// parameter or header of --cache-bust. The parameter is appended to the
+// query, leaving the order of any others as it was.
+func (lt *loadTest) bustCache(req *http.Request) {
+	m := lt.cfg.cache
+	v := strconv.FormatInt(lt.start.UnixNano(), 36) + "-" + strconv.FormatInt(atomic.AddInt64(&lt.cacheBusts, 1), 36)
+	if m.header != "" {
+		req.Header.Set(m.header, v)
+		return
+	}
+	param := url.QueryEscape(m.param) + "=" + v
+	if req.URL.RawQuery == "" {
+		req.URL.RawQuery = param
+		return
+	}
+	req.URL.RawQuery += "&" + param
+}
+
+// validators are the ETag and Last-Modified of a response, which make a later
+// request for the same URL conditional
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// validatorStore holds the validators of the latest response from each URL,
+// for --revalidate. Only GET and HEAD requests are made conditional. It is
+// safe for concurrent use.
+type validatorStore struct {
+	mu    sync.Mutex
+	byURL map[string]validators
+}
+
+func newValidatorStore() *validatorStore {
+	return &validatorStore{byURL: map[string]validators{}}
+}
+
+// conditional returns true if req can be made conditional
+func conditional(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// apply makes req conditional on the validators held for its URL, returning
+// false if there are none, such as for the first request to each URL
+func (vs *validatorStore) apply(req *http.Request) bool {
+	if !conditional(req) {
+		return false
+	}
+	vs.mu.Lock()
+	v, ok := vs.byURL[req.URL.String()]
+	vs.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+	return true
+}
+
+// store holds the validators of resp, the response to req. A 304 Not
+// Modified which omits a validator keeps the one held, and a response with
+// neither validator stops later requests being conditional.
+func (vs *validatorStore) store(req *http.Request, resp *http.Response) {
+	if !conditional(req) || (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified) {
+		return
+	}
+	v := validators{etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	key := req.URL.String()
+
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	held, ok := vs.byURL[key]
+	if resp.StatusCode == http.StatusNotModified {
+		if v.etag == "" {
+			v.etag = held.etag
+		}
+		if v.lastModified == "" {
+			v.lastModified = held.lastModified
+		}
+	}
+	switch {
+	case v == validators{}:
+		delete(vs.byURL, key)
+	case ok || len(vs.byURL) < maxValidators:
+		vs.byURL[key] = v
+	}
+}
+
+// logRevalidation logs how many requests were conditional, and how many of
+// those were answered 304 Not Modified rather than with the full response
+func (lt *loadTest) logRevalidation(logger *xlog.Logger) {
+	c := lt.stats.all.counts()
+	if c.conditional == 0 {
+		logger.Infof("Revalidation: no requests were conditional, as no responses had an ETag or Last-Modified")
+		return
+	}
+	share := 100 * float64(c.notModified) / float64(c.conditional)
+	logger.Infof("Revalidation: %d of %d requests were conditional, of which %d (%.1f%%) were 304 Not Modified and %d were full fetches", c.conditional, c.sent(), c.notModified, share, c.conditional-c.notModified)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerExpectation is a check that a response header is present and, if
+// value is set, that it contains value
+type headerExpectation struct {
+	name  string
+	value string
+}
+
+// parseHeaderExpectation parses an expectation of the form "Name" or
+// "Name: value"
+func parseHeaderExpectation(s string) (headerExpectation, error) {
+	name, value := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		name, value = s[:i], s[i+1:]
+	}
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if name == "" {
+		return headerExpectation{}, fmt.Errorf("invalid header expectation %q: missing header name", s)
+	}
+	return headerExpectation{name: name, value: value}, nil
+}
+
+// check returns an error if the expectation is not met by h
+func (e headerExpectation) check(h http.Header) error {
+	values, ok := h[http.CanonicalHeaderKey(e.name)]
+	if !ok {
+		return fmt.Errorf("missing header %q", e.name)
+	}
+	if e.value == "" {
+		return nil
+	}
+	for _, v := range values {
+		if strings.Contains(v, e.value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("header %q is %q, expected it to contain %q", e.name, strings.Join(values, ", "), e.value)
+}
+
+// checkResponse returns an error describing why resp should be counted as a
+// failure, or nil if it is OK
+func checkResponse(resp *http.Response, okCodes statusCodes, expectHeaders []headerExpectation) error {
+	if !okCodes.contains(resp.StatusCode) {
+		return fmt.Errorf("unexpected code %q", resp.Status)
+	}
+
+	for _, e := range expectHeaders {
+		if err := e.check(resp.Header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
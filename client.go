@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newClient returns a client for a single load test, configured according to
+// cfg. Each client has its own transport, and so its own connection pool. If
+// dns is not nil, host names are resolved with it. If --digest-auth is set,
+// challenges are answered.
+func newClient(cfg config, dns *dnsResolver) *http.Client {
+	t := newTransport(cfg, dns)
+	var rt http.RoundTripper = t
+	if cfg.digestAuth.user != "" {
+		rt = newDigestTransport(t, cfg.digestAuth)
+	}
+	return &http.Client{
+		Transport:     rt,
+		CheckRedirect: checkRedirect(cfg.followRedirects, cfg.maxRedirects),
+		Timeout:       cfg.timeout,
+	}
+}
+
+// baseTransport returns the transport underlying c, which sends requests
+// once any authentication is added
+func baseTransport(c *http.Client) *http.Transport {
+	if d, ok := c.Transport.(*digestTransport); ok {
+		return d.base
+	}
+	return c.Transport.(*http.Transport)
+}
+
+// checkRedirect returns a redirect policy for the client. When redirects are
+// not followed, or once max redirects have been followed, the last 3xx
+// response is returned as-is so that it is counted by its status code.
+func checkRedirect(follow bool, max int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if !follow || len(via) > max {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// newTransport returns a transport for a load test, tuned according to cfg,
+// which resolves host names with dns if it is not nil
+func newTransport(cfg config, dns *dnsResolver) *http.Transport {
+	dialer := net.Dialer{
+		Timeout:   cfg.connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	nextAddr := newRotator(len(cfg.localAddrs))
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// Each connection is made from the next local address in turn
+		d := dialer
+		if len(cfg.localAddrs) > 0 {
+			d.LocalAddr = &net.TCPAddr{IP: cfg.localAddrs[nextAddr.next()]}
+		}
+		var conn net.Conn
+		var err error
+		if dns != nil {
+			conn, err = dns.dialContext(ctx, network, addr, d.DialContext)
+		} else {
+			conn, err = d.DialContext(ctx, network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			if err := tcp.SetNoDelay(cfg.tcpNoDelay); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if cfg.throttle > 0 {
+			conn = newThrottledConn(conn, cfg.throttle)
+		}
+		return conn, nil
+	}
+
+	// The per host limit decides how many connections are kept, so don't
+	// limit the total number across all hosts
+	t.MaxIdleConns = 0
+	t.MaxIdleConnsPerHost = cfg.maxIdleConnsPerHost
+	t.MaxConnsPerHost = cfg.maxConnsPerHost
+	t.IdleConnTimeout = cfg.idleConnTimeout
+	t.ResponseHeaderTimeout = cfg.headerTimeout
+	t.DisableKeepAlives = cfg.disableKeepAlive
+	if tlsConfig := newTLSConfig(cfg); tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	return t
+}
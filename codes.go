@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// codeRange is an inclusive range of HTTP status codes
+type codeRange struct {
+	min, max int
+}
+
+// statusCodes is a set of HTTP status codes
+type statusCodes []codeRange
+
+// parseStatusCodes parses status codes given as single codes such as "302",
+// ranges such as "200-299" or classes such as "2xx"
+func parseStatusCodes(specs []string) (statusCodes, error) {
+	var codes statusCodes
+	for _, spec := range specs {
+		r, err := parseCodeRange(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, r)
+	}
+	return codes, nil
+}
+
+// parseCodeRange parses a single code, range or class of status codes
+func parseCodeRange(s string) (codeRange, error) {
+	invalid := func(reason string) (codeRange, error) {
+		return codeRange{}, fmt.Errorf("invalid status code %q: %s", s, reason)
+	}
+
+	if len(s) == 3 && strings.ToLower(s[1:]) == "xx" {
+		class := int(s[0] - '0')
+		if class < 1 || class > 5 {
+			return invalid("class must be 1xx to 5xx")
+		}
+		return codeRange{min: class * 100, max: class*100 + 99}, nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	var r codeRange
+	var err error
+	if r.min, err = strconv.Atoi(parts[0]); err != nil {
+		return invalid("expected a code such as 200, a range such as 200-299 or a class such as 2xx")
+	}
+	r.max = r.min
+	if len(parts) == 2 {
+		if r.max, err = strconv.Atoi(parts[1]); err != nil {
+			return invalid("expected a range such as 200-299")
+		}
+	}
+	if r.min < 100 || r.max > 599 {
+		return invalid("codes must be between 100 and 599")
+	}
+	if r.min > r.max {
+		return invalid("the start of the range is after its end")
+	}
+	return r, nil
+}
+
+// contains returns true if code is one of the codes
+func (c statusCodes) contains(code int) bool {
+	for _, r := range c {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the codes as they could be given to parseStatusCodes
+func (c statusCodes) String() string {
+	var s []string
+	for _, r := range c {
+		if r.min == r.max {
+			s = append(s, strconv.Itoa(r.min))
+		} else {
+			s = append(s, fmt.Sprintf("%d-%d", r.min, r.max))
+		}
+	}
+	return strings.Join(s, ",")
+}
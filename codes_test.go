@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStatusCodes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    statusCodes
+		wantErr bool
+	}{
+		{in: "200", want: statusCodes{{200, 200}}},
+		{in: "200-299", want: statusCodes{{200, 299}}},
+		{in: "2xx", want: statusCodes{{200, 299}}},
+		{in: "5XX", want: statusCodes{{500, 599}}},
+		{in: "2xx, 302,404-410", want: statusCodes{{200, 299}, {302, 302}, {404, 410}}},
+		{in: "0xx", wantErr: true},
+		{in: "6xx", wantErr: true},
+		{in: "99", wantErr: true},
+		{in: "600", wantErr: true},
+		{in: "299-200", wantErr: true},
+		{in: "200-", wantErr: true},
+		{in: "ok", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseStatusCodes(strings.Split(tt.in, ","))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusCodesContains(t *testing.T) {
+	codes := statusCodes{{200, 299}, {302, 302}}
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{199, false},
+		{200, true},
+		{299, true},
+		{301, false},
+		{302, true},
+		{500, false},
+	}
+	for _, tt := range tests {
+		if got := codes.contains(tt.code); got != tt.want {
+			t.Errorf("contains(%d): got %v, want %v", tt.code, got, tt.want)
+		}
+	}
+	if got, want := codes.String(), "200-299,302"; got != want {
+		t.Errorf("String(): got %q, want %q", got, want)
+	}
+}
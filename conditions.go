@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conditionPattern matches a condition such as "error_rate>50%" or "p99<500ms"
+var conditionPattern = regexp.MustCompile(`^\s*([a-z0-9_]+)\s*(<=|>=|<|>)\s*(\S+)\s*$`)
+
+// latencyMetrics are the latency percentiles that may be used in conditions
+var latencyMetrics = map[string]float64{
+	"p50": 50,
+	"p90": 90,
+	"p95": 95,
+	"p99": 99,
+	"max": 100,
+}
+
+// condition is a comparison of a metric against a threshold. The metric is
+// either error_rate, compared against a percentage or fraction, or a latency
+// percentile, such as p99, compared against a duration.
+type condition struct {
+	text      string
+	metric    string
+	op        string
+	threshold float64 // a fraction for error_rate, otherwise seconds
+}
+
+// parseCondition parses a condition of the form "METRIC OP VALUE", such as
+// "error_rate>50%" or "p99<500ms"
+func parseCondition(s string) (condition, error) {
+	m := conditionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return condition{}, fmt.Errorf("invalid condition %q: expected the form \"p99<500ms\"", s)
+	}
+	c := condition{text: strings.TrimSpace(s), metric: m[1], op: m[2]}
+
+	switch {
+	case c.metric == "error_rate":
+		v := m[3]
+		percent := strings.HasSuffix(v, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid condition %q: %q is not a percentage", s, v)
+		}
+		if percent {
+			f /= 100
+		}
+		c.threshold = f
+	case latencyMetrics[c.metric] > 0:
+		d, err := time.ParseDuration(m[3])
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid condition %q: %q is not a duration", s, m[3])
+		}
+		c.threshold = d.Seconds()
+	default:
+		return condition{}, fmt.Errorf("invalid condition %q: unknown metric %q", s, c.metric)
+	}
+	return c, nil
+}
+
+// holds returns true if the condition holds for a metric value of v
+func (c condition) holds(v float64) bool {
+	switch c.op {
+	case "<":
+		return v < c.threshold
+	case "<=":
+		return v <= c.threshold
+	case ">":
+		return v > c.threshold
+	default:
+		return v >= c.threshold
+	}
+}
+
+// value returns the value of the condition's metric given the counts and
+// total latencies of a set of requests
+func (c condition) value(cnt counts, q quantiles) float64 {
+	if c.metric == "error_rate" {
+		if cnt.sent() == 0 {
+			return 0
+		}
+		return float64(cnt.failed) / float64(cnt.sent())
+	}
+	return q.percentile(latencyMetrics[c.metric]).Seconds()
+}
+
+// format formats v as a value of the condition's metric
+func (c condition) format(v float64) string {
+	if c.metric == "error_rate" {
+		return fmt.Sprintf("%.1f%%", v*100)
+	}
+	return time.Duration(v * float64(time.Second)).Round(time.Microsecond).String()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// defaultConfigFile is the config file read when --config is not given
+const defaultConfigFile = "slt.yaml"
+
+// loadConfigFile applies the settings from the config file and SLT_*
+// environment variables to any flags that were not set on the command line,
+// so that flags take precedence over the environment, which takes precedence
+// over the config file. If no config file is given, slt.yaml is read from
+// the current directory if it exists.
+//
+// The targets from the "targets" setting (SLT_TARGETS, separated by spaces)
+// are returned, to be used if none are given on the command line, along with
+// the named checks from the "checks" setting. See parseConfigTargets and
+// parseConfigChecks for their formats.
+func loadConfigFile(flags *pflag.FlagSet, path string) ([]target, []namedCheck, error) {
+	v := viper.New()
+	v.SetEnvPrefix("slt")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	// The default file is named explicitly, as searching for "slt" would also
+	// find a file with no extension, such as the slt binary itself
+	file := path
+	if file == "" {
+		file = defaultConfigFile
+	}
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		if path != "" || !os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("unable to read config file: %w", err)
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || !v.IsSet(f.Name) {
+			return
+		}
+		if setErr := setFlag(f, v.Get(f.Name)); setErr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", f.Name, setErr)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var targets []target
+	if v.IsSet("targets") {
+		if targets, err = parseConfigTargets(v.Get("targets")); err != nil {
+			return nil, nil, err
+		}
+	}
+	var checks []namedCheck
+	if v.IsSet("checks") {
+		if checks, err = parseConfigChecks(v.Get("checks")); err != nil {
+			return nil, nil, err
+		}
+	}
+	return targets, checks, nil
+}
+
+// parseConfigTargets parses the "targets" setting, which is a list of
+// targets each given either as "[tag=]URL" or as a map with its own method,
+// headers, body and weight, or from the environment as a string of targets
+// separated by spaces:
+//
+//	targets:
+//	  - https://example.com/items
+//	  - tag: create
+//	    url: https://example.com/items
+//	    method: POST
+//	    headers:
+//	      Content-Type: application/json
+//	    body: '{"name": "widget"}'    # or body_file: item.json
+//	    weight: 2
+func parseConfigTargets(value interface{}) ([]target, error) {
+	var items []interface{}
+	switch value := value.(type) {
+	case string:
+		for _, s := range strings.Fields(value) {
+			items = append(items, s)
+		}
+	case []interface{}:
+		items = value
+	default:
+		return nil, fmt.Errorf("invalid targets: expected a list, not %v", value)
+	}
+
+	var targets []target
+	for i, item := range items {
+		var t target
+		var err error
+		if m, ok := configMap(item); ok {
+			t, err = parseConfigTarget(m)
+		} else {
+			t, err = parseTarget(fmt.Sprint(item))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %d: %w", i+1, err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// parseConfigTarget parses a target given as a map in the config file
+func parseConfigTarget(m map[string]interface{}) (target, error) {
+	for key := range m {
+		switch key {
+		case "url", "tag", "method", "headers", "body", "body_file", "weight":
+		default:
+			return target{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	if m["url"] == nil {
+		return target{}, errors.New("url is required")
+	}
+	t, err := parseTarget(fmt.Sprint(m["url"]))
+	if err != nil {
+		return target{}, err
+	}
+	if tag, ok := m["tag"]; ok {
+		t.tag = fmt.Sprint(tag)
+	}
+	t.method = http.MethodGet
+	if method, ok := m["method"]; ok {
+		t.method = strings.ToUpper(fmt.Sprint(method))
+	}
+
+	t.headers = http.Header{}
+	if headers, ok := m["headers"]; ok {
+		hm, ok := configMap(headers)
+		if !ok {
+			return target{}, errors.New("headers must be a map of names to values")
+		}
+		for name, value := range hm {
+			t.headers.Set(name, fmt.Sprint(value))
+		}
+	}
+
+	if body, ok := m["body"]; ok {
+		if _, ok := m["body_file"]; ok {
+			return target{}, errors.New("only one of body and body_file may be given")
+		}
+		t.body = []byte(fmt.Sprint(body))
+	}
+	if path, ok := m["body_file"]; ok {
+		if t.body, err = os.ReadFile(fmt.Sprint(path)); err != nil {
+			return target{}, err
+		}
+	}
+
+	if weight, ok := m["weight"]; ok {
+		if t.weight, err = parseWeight(fmt.Sprint(weight)); err != nil {
+			return target{}, err
+		}
+	}
+	return t, nil
+}
+
+// configMap returns value as a map with string keys, if it is a map. YAML
+// maps nested in lists are decoded with keys of any type.
+func configMap(value interface{}) (map[string]interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, true
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, v := range value {
+			m[fmt.Sprint(k)] = v
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// setFlag sets the flag f to value, which comes either from the environment
+// as a string, or from the config file as a string, number, bool, list or
+// map
+func setFlag(f *pflag.Flag, value interface{}) error {
+	switch value := value.(type) {
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = fmt.Sprint(v)
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			return sv.Replace(values)
+		}
+		return f.Value.Set(strings.Join(values, ","))
+	case map[string]interface{}:
+		var pairs []string
+		for k, v := range value {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(pairs)
+		return f.Value.Set(strings.Join(pairs, ","))
+	default:
+		return f.Value.Set(fmt.Sprint(value))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigTargets(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "item.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"name": "file"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      interface{}
+		want    []target
+		wantErr bool
+	}{
+		{
+			name: "environment",
+			in:   "https://example.com/a  b=https://example.com/b",
+			want: []target{
+				{tag: "https://example.com/a", url: "https://example.com/a"},
+				{tag: "b", url: "https://example.com/b"},
+			},
+		},
+		{
+			name: "list",
+			in:   []interface{}{"https://example.com/a", "b=https://example.com/b"},
+			want: []target{
+				{tag: "https://example.com/a", url: "https://example.com/a"},
+				{tag: "b", url: "https://example.com/b"},
+			},
+		},
+		{
+			name: "map",
+			in: []interface{}{map[interface{}]interface{}{
+				"tag":     "create",
+				"url":     "https://example.com/items",
+				"method":  "post",
+				"headers": map[interface{}]interface{}{"content-type": "application/json"},
+				"body":    `{"name": "widget"}`,
+				"weight":  2,
+			}},
+			want: []target{{
+				tag: "create", url: "https://example.com/items", method: "POST",
+				headers: http.Header{"Content-Type": {"application/json"}},
+				body:    []byte(`{"name": "widget"}`),
+				weight:  2,
+			}},
+		},
+		{
+			name: "body file",
+			in:   []interface{}{map[string]interface{}{"url": "https://example.com/items", "body_file": bodyFile}},
+			want: []target{{
+				tag: "https://example.com/items", url: "https://example.com/items", method: "GET",
+				headers: http.Header{},
+				body:    []byte(`{"name": "file"}`),
+			}},
+		},
+		{name: "not a list", in: 3, wantErr: true},
+		{name: "no url", in: []interface{}{map[string]interface{}{"tag": "a"}}, wantErr: true},
+		{name: "unknown setting", in: []interface{}{map[string]interface{}{"url": "https://example.com", "verb": "GET"}}, wantErr: true},
+		{name: "body and body file", in: []interface{}{map[string]interface{}{"url": "https://example.com", "body": "a", "body_file": bodyFile}}, wantErr: true},
+		{name: "missing body file", in: []interface{}{map[string]interface{}{"url": "https://example.com", "body_file": bodyFile + ".missing"}}, wantErr: true},
+		{name: "headers not a map", in: []interface{}{map[string]interface{}{"url": "https://example.com", "headers": "a"}}, wantErr: true},
+		{name: "bad weight", in: []interface{}{map[string]interface{}{"url": "https://example.com", "weight": 0}}, wantErr: true},
+		{name: "bad url", in: []interface{}{"https://example.com/%zz"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfigTargets(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// logConnections logs how many requests opened a new connection rather than
+// re-using an idle one, and the rate at which they were opened. A high share
+// means the target or a proxy is closing connections, or there aren't enough
+// idle connections kept for the rate.
+func (lt *loadTest) logConnections(logger *xlog.Logger) {
+	c := lt.stats.all.counts()
+	if c.sent() == 0 {
+		return
+	}
+	share := 100 * float64(c.newConns) / float64(c.sent())
+	elapsed := time.Since(lt.start) - lt.pausedFor()
+	logger.Infof("Connections: %d of %d requests opened a new connection (%.1f%%), %d re-used one, %.1f new connections/s", c.newConns, c.sent(), share, c.sent()-c.newConns, float64(c.newConns)/elapsed.Seconds())
+}
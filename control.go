@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// uiPage is the web UI served by the control API, which charts the /stats
+// endpoint
+//
+//go:embed ui.html
+var uiPage []byte
+
+// controlStats is the JSON body returned by the control API's /stats
+// endpoint
+type controlStats struct {
+	Elapsed float64 `json:"elapsed_seconds"`
+	Rate    int     `json:"rate"`
+	Paused  bool    `json:"paused"`
+	controlCounts
+	Tags map[string]controlCounts `json:"tags,omitempty"`
+}
+
+// controlCounts are the statistics of the requests to all targets, or to a
+// single tag
+type controlCounts struct {
+	Requests  int                `json:"requests"`
+	OK        int                `json:"ok"`
+	Failures  int                `json:"failures"`
+	Latencies map[string]float64 `json:"latency_ms,omitempty"`
+}
+
+// startControlServer starts the control API on the listener l, returning a
+// function which stops it, closing l, and waits for any requests to it to
+// finish. The API has the endpoints:
+//
+//	GET  /                   a web UI with live charts of the load test
+//	POST /pause              stop sending requests, as does SIGUSR1
+//	POST /resume             start sending requests again, as does SIGUSR2
+//	POST /rate?rps=N         change the target request rate
+//	GET  /stats              the statistics so far, as JSON
+func (lt *loadTest) startControlServer(l net.Listener) (stop func()) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", lt.handleUI)
+	mux.HandleFunc("/pause", lt.handlePause(true))
+	mux.HandleFunc("/resume", lt.handlePause(false))
+	mux.HandleFunc("/rate", lt.handleRate)
+	mux.HandleFunc("/stats", lt.handleStats)
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if srv.Shutdown(ctx) != nil {
+			srv.Close()
+		}
+	}
+}
+
+// handleUI serves the web UI
+func (lt *loadTest) handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
+
+// handlePause returns a handler which pauses or resumes the load test
+func (lt *loadTest) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		lt.setPaused(paused)
+		lt.handleStats(w, r)
+	}
+}
+
+// handleRate changes the target request rate to the rps query parameter
+func (lt *loadTest) handleRate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	rps, err := strconv.Atoi(r.FormValue("rps"))
+	if err != nil || rps <= 0 {
+		http.Error(w, fmt.Sprintf("invalid rps %q: must be a positive integer", r.FormValue("rps")), http.StatusBadRequest)
+		return
+	}
+	lt.setRate(rps)
+	lt.handleStats(w, r)
+}
+
+// handleStats writes the statistics so far as JSON
+func (lt *loadTest) handleStats(w http.ResponseWriter, r *http.Request) {
+	elapsed := time.Since(lt.start)
+	rate, _ := lt.rate(elapsed)
+	cs := controlStats{
+		Elapsed:       elapsed.Seconds(),
+		Rate:          rate,
+		Paused:        lt.isPaused(),
+		controlCounts: newControlCounts(&lt.stats.all),
+	}
+	if len(lt.stats.tags) > 1 {
+		cs.Tags = map[string]controlCounts{}
+		for _, tag := range lt.stats.tags {
+			cs.Tags[tag] = newControlCounts(lt.stats.byTag[tag])
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cs)
+}
+
+// newControlCounts returns the statistics in s for the /stats endpoint
+func newControlCounts(s *stats) controlCounts {
+	c := s.counts()
+	return controlCounts{
+		Requests:  c.sent(),
+		OK:        c.ok,
+		Failures:  c.failed,
+		Latencies: latencyMillis(s.latencyHistogram(phaseTotal)),
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// writeCSV writes a CSV time series of lt to w, with a row for each second
+// of the load test, and a column for each named check which is pass or fail
+// in each second it was evaluated
+func writeCSV(w io.Writer, lt *loadTest, err error) error {
+	cw := csv.NewWriter(w)
+	header := []string{"second", "requests", "ok", "failures", "p50_ms", "p90_ms", "p99_ms", "max_ms"}
+	var checks []namedCheck
+	if lt.checker != nil {
+		checks = lt.checker.checks
+		for _, c := range checks {
+			header = append(header, "check_"+c.name)
+		}
+	}
+	cw.Write(header)
+	buckets, first := lt.series.buckets()
+	for i, b := range buckets {
+		row := []string{strconv.Itoa(first + i), strconv.Itoa(b.counts.sent()), strconv.Itoa(b.counts.ok), strconv.Itoa(b.counts.failed)}
+		for _, p := range summaryPercentiles {
+			if b.latencies.count() == 0 {
+				row = append(row, "")
+				continue
+			}
+			ms := float64(b.latencies.percentile(p)) / float64(time.Millisecond)
+			row = append(row, strconv.FormatFloat(ms, 'f', 3, 64))
+		}
+		for j := range checks {
+			switch lt.checker.state(j, first+i) {
+			case checkIdle:
+				row = append(row, "")
+			case checkPassing:
+				row = append(row, "pass")
+			default:
+				row = append(row, "fail")
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestCredentials are the user name and password for --digest-auth
+type digestCredentials struct {
+	user     string
+	password string
+}
+
+// parseDigestCredentials parses credentials of the form "user:pass"
+func parseDigestCredentials(s string) (digestCredentials, error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return digestCredentials{}, errors.New("invalid --digest-auth: expected user:pass")
+	}
+	return digestCredentials{user: s[:i], password: s[i+1:]}, nil
+}
+
+// digestChallenge is a challenge from a WWW-Authenticate header, which is
+// answered by each request to its host until the host sends a new one
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // MD5, MD5-sess, SHA-256 or SHA-256-sess
+	qop       string // auth, or empty if the host doesn't support it
+	nc        uint32 // number of requests which have answered the challenge
+}
+
+// digestTransport answers HTTP Digest authentication challenges (RFC 7616).
+// The first request to a host is sent without credentials, and when it is
+// challenged it is sent again with them. Later requests answer the same
+// challenge up front, so they take a single round trip until the host sends
+// a new challenge, such as when the nonce goes stale. It is safe for
+// concurrent use.
+type digestTransport struct {
+	base  *http.Transport
+	creds digestCredentials
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge // by host
+}
+
+// newDigestTransport returns a transport which sends requests with base,
+// authenticating as creds
+func newDigestTransport(base *http.Transport, creds digestCredentials) *digestTransport {
+	return &digestTransport{base: base, creds: creds, challenges: map[string]*digestChallenge{}}
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The body may need to be sent twice. It's sent as it is the first time,
+	// as a streamed body would otherwise be left unread.
+	body := func() (io.ReadCloser, error) { return req.Body, nil }
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody != nil:
+		sent := false
+		body = func() (io.ReadCloser, error) {
+			if !sent {
+				sent = true
+				return req.Body, nil
+			}
+			return req.GetBody()
+		}
+	default:
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(b)), nil }
+	}
+
+	send := func() (*http.Response, bool, error) {
+		r := req.Clone(req.Context())
+		var err error
+		if r.Body, err = body(); err != nil {
+			return nil, false, err
+		}
+		authorized := t.authorize(r)
+		resp, err := t.base.RoundTrip(r)
+		return resp, authorized, err
+	}
+	resp, authorized, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	c, ok := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok || (authorized && !c.stale) {
+		// Not a digest challenge, or the credentials were rejected
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	t.mu.Lock()
+	t.challenges[req.URL.Host] = &c.digestChallenge
+	t.mu.Unlock()
+	resp, _, err = send()
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the base transport
+func (t *digestTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// authorize sets the Authorization header of req if there is a challenge
+// from its host to answer, returning false if there isn't
+func (t *digestTransport) authorize(req *http.Request) bool {
+	t.mu.Lock()
+	c, ok := t.challenges[req.URL.Host]
+	var nc uint32
+	if ok {
+		c.nc++
+		nc = c.nc
+	}
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	var b [8]byte
+	rand.Read(b[:])
+	cnonce := hex.EncodeToString(b[:])
+	h := digestHash(c.algorithm)
+	uri := req.URL.RequestURI()
+
+	ha1 := h(t.creds.user + ":" + c.realm + ":" + t.creds.password)
+	if strings.HasSuffix(c.algorithm, "-sess") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+	var response string
+	if c.qop != "" {
+		response = h(fmt.Sprintf("%s:%s:%08x:%s:%s:%s", ha1, c.nonce, nc, cnonce, c.qop, ha2))
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	}
+
+	auth := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, response=%q`, t.creds.user, c.realm, c.nonce, uri, c.algorithm, response)
+	if c.qop != "" {
+		auth += fmt.Sprintf(`, qop=%s, nc=%08x, cnonce=%q`, c.qop, nc, cnonce)
+	}
+	if c.opaque != "" {
+		auth += fmt.Sprintf(`, opaque=%q`, c.opaque)
+	}
+	req.Header.Set("Authorization", auth)
+	return true
+}
+
+// digestHash returns a function returning the hex encoded hash of a string,
+// with the hash of the given digest algorithm
+func digestHash(algorithm string) func(string) string {
+	newHash := md5.New
+	if strings.HasPrefix(algorithm, "SHA-256") {
+		newHash = sha256.New
+	}
+	return func(s string) string {
+		h := newHash()
+		io.WriteString(h, s)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// parsedDigestChallenge is a challenge, along with whether the nonce of the
+// previous challenge had merely gone stale
+type parsedDigestChallenge struct {
+	digestChallenge
+	stale bool
+}
+
+// parseDigestChallenge returns the first digest challenge in the values of
+// WWW-Authenticate headers whose algorithm and qop are supported
+func parseDigestChallenge(headers []string) (parsedDigestChallenge, bool) {
+	for _, h := range headers {
+		if len(h) < 7 || !strings.EqualFold(h[:7], "Digest ") {
+			continue
+		}
+		params := parseAuthParams(h[7:])
+		c := parsedDigestChallenge{
+			digestChallenge: digestChallenge{realm: params["realm"], nonce: params["nonce"], opaque: params["opaque"], algorithm: params["algorithm"]},
+			stale:           strings.EqualFold(params["stale"], "true"),
+		}
+		switch strings.ToUpper(c.algorithm) {
+		case "", "MD5":
+			c.algorithm = "MD5"
+		case "MD5-SESS":
+			c.algorithm = "MD5-sess"
+		case "SHA-256":
+			c.algorithm = "SHA-256"
+		case "SHA-256-SESS":
+			c.algorithm = "SHA-256-sess"
+		default:
+			continue
+		}
+		if qop, ok := params["qop"]; ok {
+			for _, q := range strings.Split(qop, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					c.qop = "auth"
+				}
+			}
+			if c.qop == "" {
+				// Only auth-int is offered, which needs the body hashed
+				continue
+			}
+		}
+		if c.nonce != "" {
+			return c, true
+		}
+	}
+	return parsedDigestChallenge{}, false
+}
+
+// parseAuthParams parses the comma separated name=value parameters of an
+// authentication challenge, where values may be quoted
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimSpace(s[i+1:])
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++ // the closing quote
+			}
+			s = s[i:]
+		} else {
+			i = strings.Index(s, ",")
+			if i < 0 {
+				i = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		params[name] = value.String()
+		s = strings.TrimLeft(s, ", \t")
+	}
+	return params
+}
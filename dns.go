@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// dnsResolver resolves the host names a load test connects to, caching each
+// host's addresses for ttl, or resolving for every new connection if ttl is
+// zero. Each new connection is made to the next of the host's addresses in
+// turn, so that the load is spread across every backend of a DNS load
+// balanced service rather than pinned to the first answer. If an address
+// can't be connected to, the host's other addresses are tried, as Go's
+// dialer would, and the address is skipped until the host is next resolved.
+// It is safe for concurrent use.
+type dnsResolver struct {
+	ttl      time.Duration
+	onChange func() // called when a host's addresses change, if set
+
+	mu    sync.Mutex
+	hosts map[string]*dnsHost
+	names []string // in the order they were first resolved
+}
+
+// dnsHost is the cached addresses of a host, and the statistics of its
+// resolutions
+type dnsHost struct {
+	addrs       []string
+	expires     time.Time
+	next        int
+	resolutions int
+	failures    int
+	changes     int
+	conns       map[string]int  // by address
+	dialErrors  map[string]int  // by address
+	bad         map[string]bool // addresses to skip, until the next resolution
+}
+
+// newDNSResolver returns a resolver which keeps addresses for ttl
+func newDNSResolver(ttl time.Duration) *dnsResolver {
+	return &dnsResolver{ttl: ttl, hosts: map[string]*dnsHost{}}
+}
+
+// dialContext dials addr with dial, after resolving its host to the next of
+// the host's addresses, falling back to its other addresses in turn if that
+// fails. Addresses which are already IPs are dialed as they are.
+func (c *dnsResolver) dialContext(ctx context.Context, network, addr string, dial func(context.Context, string, string) (net.Conn, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(ctx, network, addr)
+	}
+	ips, err := c.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dial(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			c.connected(host, ip)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			// The request was cancelled or timed out, which isn't the
+			// address's fault
+			return nil, err
+		}
+		c.failed(host, ip)
+	}
+	return nil, err
+}
+
+// resolve returns the addresses of host in the order to try them for the
+// next connection, starting with the next in turn and ending with any which
+// recently couldn't be connected to. It looks the host up again if its
+// cached addresses have expired. While one connection looks the host up,
+// the others carry on using the expired addresses, which are also used if
+// the lookup fails.
+func (c *dnsResolver) resolve(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	h, ok := c.hosts[host]
+	if !ok {
+		h = &dnsHost{conns: map[string]int{}, dialErrors: map[string]int{}, bad: map[string]bool{}}
+		c.hosts[host] = h
+		c.names = append(c.names, host)
+	}
+	now := time.Now()
+	if len(h.addrs) == 0 || !now.Before(h.expires) {
+		h.expires = now.Add(c.ttl)
+		c.mu.Unlock()
+
+		// The lookup uses ctx, so it is timed as the request's dns phase
+		var addrs []string
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		for _, ip := range ips {
+			addrs = append(addrs, ip.String())
+		}
+
+		c.mu.Lock()
+		h.resolutions++
+		if err != nil {
+			h.failures++
+			if len(h.addrs) == 0 {
+				c.mu.Unlock()
+				return nil, err
+			}
+		} else {
+			if !sameAddrs(h.addrs, addrs) {
+				if len(h.addrs) > 0 {
+					h.changes++
+					defer c.changed()
+				}
+				h.addrs = addrs
+			}
+			h.bad = map[string]bool{}
+		}
+	}
+
+	var good, bad []string
+	for i := range h.addrs {
+		addr := h.addrs[(h.next+i)%len(h.addrs)]
+		if h.bad[addr] {
+			bad = append(bad, addr)
+		} else {
+			good = append(good, addr)
+		}
+	}
+	h.next++
+	c.mu.Unlock()
+	return append(good, bad...), nil
+}
+
+// connected records a connection to addr, an address of host
+func (c *dnsResolver) connected(host, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hosts[host].conns[addr]++
+}
+
+// failed records that addr, an address of host, couldn't be connected to, so
+// that it is tried last until the host is next resolved
+func (c *dnsResolver) failed(host, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.hosts[host]
+	h.dialErrors[addr]++
+	h.bad[addr] = true
+}
+
+// changed is called, without c.mu held, when a host's addresses change
+func (c *dnsResolver) changed() {
+	if c.onChange != nil {
+		c.onChange()
+	}
+}
+
+// log logs how often each host was resolved, and the connections made to
+// each of its addresses
+func (c *dnsResolver) log(logger *xlog.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range c.names {
+		h := c.hosts[name]
+		logger.Infof("DNS: %s resolved %d times, %d failures, %d changes of address", name, h.resolutions, h.failures, h.changes)
+
+		var addrs []string
+		for addr := range h.conns {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		var conns []string
+		for _, addr := range addrs {
+			conns = append(conns, fmt.Sprintf("%s %d", addr, h.conns[addr]))
+		}
+		if len(conns) > 0 {
+			logger.Infof("DNS: %s connections by address: %s", name, strings.Join(conns, ", "))
+		}
+
+		addrs = addrs[:0]
+		for addr := range h.dialErrors {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		var errs []string
+		for _, addr := range addrs {
+			errs = append(errs, fmt.Sprintf("%s %d", addr, h.dialErrors[addr]))
+		}
+		if len(errs) > 0 {
+			logger.Warnf("DNS: %s addresses which couldn't be connected to, and were skipped: %s", name, strings.Join(errs, ", "))
+		}
+	}
+}
+
+// sameAddrs returns true if a and b hold the same addresses, in any order
+func sameAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, addr := range a {
+		seen[addr] = true
+	}
+	for _, addr := range b {
+		if !seen[addr] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+const (
+	// drainPollInterval is how often the requests in flight are counted
+	// while draining
+	drainPollInterval = 10 * time.Millisecond
+
+	// drainCancelWait bounds how long cancelled requests are waited for to
+	// return, which they do as soon as their connections are closed
+	drainCancelWait = time.Second
+)
+
+// drain waits up to --drain for the requests in flight when the load test
+// ended to complete, so that their results are counted, then cancels any
+// which haven't with cancelRequests. The cancelled requests are waited for,
+// so that none of their goroutines or connections outlive the load test and
+// interfere with the next one run by the same process.
+func (lt *loadTest) drain(cancelRequests context.CancelFunc) {
+	if n := atomic.LoadInt64(&lt.inFlight); n > 0 && lt.cfg.drain > 0 {
+		lt.logger.Infof("Waiting up to %v for %d requests in flight to complete", lt.cfg.drain, n)
+	}
+	deadline := time.Now().Add(lt.cfg.drain)
+	for atomic.LoadInt64(&lt.inFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	lt.cancelled = atomic.LoadInt64(&lt.inFlight)
+	cancelRequests()
+	deadline = time.Now().Add(drainCancelWait)
+	for atomic.LoadInt64(&lt.inFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	if n := atomic.LoadInt64(&lt.inFlight); n > 0 {
+		lt.logger.Warnf("%d cancelled requests had not returned after %v", n, drainCancelWait)
+	}
+}
+
+// logCancelled logs how many requests were cancelled as they were still in
+// flight when the load test ended
+func (lt *loadTest) logCancelled(logger *xlog.Logger) {
+	if lt.cancelled == 0 {
+		return
+	}
+	if lt.cfg.drain > 0 {
+		logger.Infof("Cancelled: %d requests were still in flight %v after the load test ended, and aren't counted", lt.cancelled, lt.cfg.drain)
+	} else {
+		logger.Infof("Cancelled: %d requests were in flight when the load test ended, and aren't counted; use --drain to wait for them", lt.cancelled)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// sensitiveHeaderWords are the words which, when found in a header name, mark
+// the header's value as a secret
+var sensitiveHeaderWords = []string{"auth", "cookie", "key", "password", "secret", "session", "token"}
+
+// runDryRun validates cfg and logs what the load test would do, without
+// sending any load. If probe is true, a single request is sent to each
+// target. An error is returned if any target's host cannot be resolved, or
+// any probe fails.
+func runDryRun(ctx context.Context, logger *xlog.Logger, cfg config, probe bool) error {
+	lt, err := newLoadTest(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Dry run: no load will be generated")
+	if len(cfg.pattern) > 0 {
+		logger.Infof("Rate: the pattern %s, repeating every %v", cfg.pattern, cfg.pattern.period())
+	} else {
+		logger.Infof("Rate: %d requests per second", cfg.rps)
+	}
+	if cfg.workers > 0 {
+		logger.Infof("Workers: %d", cfg.workers)
+		if cfg.maxQueue > 0 {
+			logger.Infof("Queue: up to %d requests, beyond which requests are skipped", cfg.maxQueue)
+		}
+	} else {
+		logger.Infof("Workers: added as needed")
+	}
+	if cfg.clientPerWorker {
+		logger.Infof("Clients: one per worker, each with its own connection pool and cookie jar")
+	}
+	switch {
+	case cfg.duration > 0 && cfg.requests > 0:
+		logger.Infof("Stops: after %v or %d requests, whichever is first", cfg.duration, cfg.requests)
+	case cfg.duration > 0 && len(cfg.pattern) > 0:
+		logger.Infof("Stops: after %v", cfg.duration)
+	case cfg.duration > 0:
+		logger.Infof("Stops: after %v (about %d requests)", cfg.duration, int(cfg.duration.Seconds())*cfg.rps)
+	case cfg.requests > 0:
+		logger.Infof("Stops: after %d requests", cfg.requests)
+	default:
+		logger.Infof("Stops: when interrupted")
+	}
+	if cfg.drain > 0 {
+		logger.Infof("Drain: up to %v for requests in flight when the load test ends", cfg.drain)
+	}
+	logger.Infof("Timeout: %v per request, %v to connect", cfg.timeout, cfg.connectTimeout)
+	if cfg.headerTimeout > 0 {
+		logger.Infof("Response header timeout: %v", cfg.headerTimeout)
+	}
+	logger.Infof("Response bodies: %s", cfg.readBody)
+	for _, rf := range cfg.results {
+		logger.Infof("Raw results: streamed to %s as %s", rf.path, rf.format)
+	}
+	for _, s := range cfg.sinks {
+		logger.Infof("Sink: metrics pushed to %s every %v", s, cfg.sinkInterval)
+	}
+	if lt.signer != nil {
+		logger.Infof("AWS SigV4: signed for %s in %s, with credentials from the %s", cfg.awsSigV4.service, cfg.awsSigV4.region, lt.signer.source)
+	}
+	if cfg.digestAuth.user != "" {
+		logger.Infof("Digest auth: as %s", cfg.digestAuth.user)
+	}
+	if cfg.traceLog != "" {
+		logger.Infof("Trace log: %g%% of requests written to %s", 100*cfg.traceSample, cfg.traceLog)
+	}
+	if cfg.maxMemory > 0 {
+		logger.Infof("Memory limit: %s", formatBytes(cfg.maxMemory))
+	}
+	if cfg.dnsTTL > 0 {
+		logger.Infof("DNS: addresses cached for %v", cfg.dnsTTL)
+	} else {
+		logger.Infof("DNS: resolved for every new connection")
+	}
+	if cfg.disableKeepAlive {
+		logger.Infof("Connections: a new one for every request")
+	}
+	if cfg.throttle > 0 {
+		logger.Infof("Throttle: each connection limited to %s in each direction", cfg.throttle)
+	}
+	if cfg.sni != "" {
+		logger.Infof("TLS server name: %s", cfg.sni)
+	}
+	switch len(cfg.localAddrs) {
+	case 0:
+	case 1:
+		logger.Infof("Local address: %s", cfg.localAddrs[0])
+	default:
+		logger.Infof("Local addresses: %d from %s to %s, rotated per connection", len(cfg.localAddrs), cfg.localAddrs[0], cfg.localAddrs[len(cfg.localAddrs)-1])
+	}
+	if cfg.thinkTime.max > 0 {
+		logger.Infof("Think time: %v to %v after each request", cfg.thinkTime.min, cfg.thinkTime.max)
+	}
+	if cfg.followRedirects {
+		logger.Infof("Redirects: followed, up to %d per request", cfg.maxRedirects)
+	} else {
+		logger.Infof("Redirects: not followed")
+	}
+	logger.Infof("OK codes: %s", cfg.okCodes)
+	if len(cfg.expectCodes) > 0 {
+		logger.Infof("Expected codes: %v", cfg.expectCodes)
+	}
+	if !cfg.cache.isDefault() {
+		logger.Infof("Cache mode: %s", cfg.cache.describe())
+	}
+	for _, c := range cfg.checks {
+		logger.Infof("Check %s: %s, for %s", c.name, c.text, c.scope())
+	}
+	if cfg.apdex.satisfied > 0 {
+		logger.Infof("Apdex: satisfied up to %v, tolerating up to %v", cfg.apdex.satisfied, cfg.apdex.frustrated)
+	}
+	for _, e := range cfg.expectHeaders {
+		if e.value == "" {
+			logger.Infof("Expect header: %s", e.name)
+		} else {
+			logger.Infof("Expect header: %s containing %q", e.name, e.value)
+		}
+	}
+	if len(cfg.userAgents) > 0 {
+		logger.Infof("User-Agent: rotating through %d values", len(cfg.userAgents))
+	}
+	for _, h := range cfg.headerRotations {
+		logger.Infof("%s: rotating through %d values", h.name, len(h.values))
+	}
+
+	if cfg.setup != nil {
+		logger.Infof("Setup: %s %s, sent once before the load test", cfg.setup.method, cfg.setup.url)
+		for _, e := range cfg.extractions {
+			logger.Infof("  Extract %s from %s %s", e.name, e.source, e.expr)
+		}
+	}
+
+	failed := false
+	for _, t := range lt.targets {
+		if len(t.params) > 0 {
+			// The URL is shown with its placeholders, rather than escaped
+			logger.Infof("Target %s: %s %s", t.tag, t.req.Method, t.url)
+		} else if t.tag != t.url {
+			logger.Infof("Target %s: %s %s", t.tag, t.req.Method, t.req.URL)
+		} else {
+			logger.Infof("Target: %s %s", t.req.Method, t.req.URL)
+		}
+		if t.weight > 0 {
+			logger.Infof("  Weight: %d", t.weight)
+		}
+		for _, line := range maskedHeaders(t.req.Header) {
+			logger.Infof("  %s", line)
+		}
+		if len(t.body) > 0 {
+			logger.Infof("  Body: %d bytes", len(t.body))
+		}
+		for _, f := range cfg.form {
+			if f.file != "" {
+				logger.Infof("  Form field %s: file %s", f.name, f.file)
+			} else {
+				logger.Infof("  Form field %s: %q", f.name, f.value)
+			}
+		}
+
+		if err := resolve(ctx, logger, t.req.URL.Hostname()); err != nil {
+			logger.Errorf("  %s", err)
+			failed = true
+			continue
+		}
+
+		if probe {
+			if err := lt.probe(ctx, t); err != nil {
+				logger.Errorf("  Probe failed: %s", err)
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("dry run failed")
+	}
+	return nil
+}
+
+// resolve checks that host can be resolved, logging the addresses it
+// resolves to
+func resolve(ctx context.Context, logger *xlog.Logger, host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %s: %w", host, err)
+	}
+	logger.Infof("  %s resolves to %s", host, strings.Join(addrs, ", "))
+	return nil
+}
+
+// probe sends a single request to t and logs the result
+func (lt *loadTest) probe(ctx context.Context, t target) error {
+	tr := newTracer()
+	req := lt.newRequest(httptrace.WithClientTrace(ctx, tr.clientTrace()), t)
+	if err := lt.sign(req); err != nil {
+		return err
+	}
+	resp, err := lt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	timings := tr.finish()
+	lt.logger.Infof("  Probe returned %q in %v", resp.Status, timings[phaseTotal].Round(time.Microsecond))
+	return checkResponse(resp, lt.cfg.okCodes, lt.cfg.expectHeaders)
+}
+
+// maskedHeaders returns the headers in h as sorted "Name: value" lines, with
+// the values of any sensitive headers masked
+func maskedHeaders(h http.Header) []string {
+	var lines []string
+	for name, values := range h {
+		for _, v := range values {
+			if isSensitiveHeader(name) {
+				v = maskValue(v)
+			}
+			lines = append(lines, name+": "+v)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// isSensitiveHeader returns true if the value of the header called name is
+// likely to be a secret
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range sensitiveHeaderWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskValue masks all but the first few characters of a secret value
+func maskValue(v string) string {
+	const shown = 4
+	if len(v) <= shown*2 {
+		return strings.Repeat("*", len(v))
+	}
+	return v[:shown] + strings.Repeat("*", len(v)-shown)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xfxdev/xlog"
+)
+
+// isExpectedCode returns true if code is one of the --expect-codes, which are
+// counted as OK without checking the rest of the response
+func isExpectedCode(code int, expectCodes []int) bool {
+	for _, c := range expectCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedOnset describes when the target started returning the expected
+// codes, such as a rate limiter starting to return 429s as load rises
+type expectedOnset struct {
+	second      int     // of the load test in which the first was returned
+	rate        int     // requests completed in that second
+	consistency float64 // fraction of the seconds since which returned any
+	share       float64 // fraction of the requests since which got one
+}
+
+// expectedOnset returns when the expected codes were first returned, or false
+// if they never were, within the seconds kept in the time series
+func (lt *loadTest) expectedOnset() (expectedOnset, bool) {
+	buckets, offset := lt.series.buckets()
+	first := -1
+	for i, b := range buckets {
+		if b.counts.expected > 0 {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return expectedOnset{}, false
+	}
+
+	o := expectedOnset{second: offset + first, rate: buckets[first].counts.sent()}
+	var seconds, sent, expected int
+	for _, b := range buckets[first:] {
+		if b.counts.expected > 0 {
+			seconds++
+		}
+		sent += b.counts.sent()
+		expected += b.counts.expected
+	}
+	o.consistency = float64(seconds) / float64(len(buckets)-first)
+	o.share = float64(expected) / float64(sent)
+	return o, true
+}
+
+// logExpected logs how many responses had the expected codes, and when they
+// started
+func (lt *loadTest) logExpected(logger *xlog.Logger) {
+	codes := formatCodes(lt.cfg.expectCodes)
+	c := lt.stats.all.counts()
+	if c.sent() == 0 {
+		return
+	}
+	logger.Infof("Expected codes %s: %d of %d responses (%.1f%%)", codes, c.expected, c.sent(), 100*float64(c.expected)/float64(c.sent()))
+
+	o, ok := lt.expectedOnset()
+	if !ok {
+		logger.Warnf("The target never returned %s", codes)
+		return
+	}
+	logger.Infof("First returned %ds into the load test, when %d requests completed that second; since then %.0f%% of seconds returned any, and %.0f%% of requests got one", o.second, o.rate, 100*o.consistency, 100*o.share)
+}
+
+// formatCodes formats status codes as a list, such as "429 or 503"
+func formatCodes(codes []int) string {
+	var s []string
+	for _, c := range codes {
+		s = append(s, fmt.Sprint(c))
+	}
+	return strings.Join(s, " or ")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xfxdev/xlog"
+)
+
+var (
+	findMaxResolution int
+	findMaxMaxRPS     int
+	findMaxSLA        []string
+	findMaxStartRPS   int
+	findMaxStepLength time.Duration
+)
+
+// errInterrupted is returned when a find-max step is interrupted
+var errInterrupted = errors.New("interrupted")
+
+var findMaxCmd = &cobra.Command{
+	Use:   "find-max [flags] [tag=]URL...",
+	Short: "Find the highest request rate that meets an SLA",
+	Long: `Find the highest request rate that meets an SLA.
+
+A load test is run at --start-rps for --step-duration. While every SLA
+condition holds, the rate is doubled and the test repeated, until a condition
+is broken or --max-rps is reached. The rate is then binary searched between
+the highest rate that met the SLA and the lowest that did not, until they are
+within --resolution requests per second of each other.
+
+SLA conditions are given as "METRIC OP VALUE", where METRIC is error_rate or
+a latency percentile (p50, p90, p95, p99 or max), such as "p99<500ms" or
+"error_rate<1%". All other flags apply to each step as they do to a normal
+load test.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger, cfg, err := setup(cmd, args)
+		if err != nil {
+			return err
+		}
+
+		var sla []condition
+		for _, s := range findMaxSLA {
+			c, err := parseCondition(s)
+			if err != nil {
+				return err
+			}
+			sla = append(sla, c)
+		}
+		if len(sla) == 0 {
+			return errors.New("at least one --sla condition is required")
+		}
+		if findMaxStartRPS <= 0 || findMaxMaxRPS < findMaxStartRPS {
+			return errors.New("--start-rps must be positive and no more than --max-rps")
+		}
+
+		return findMax(cmd.Context(), logger, cfg, sla)
+	},
+}
+
+// findMax searches for the highest request rate at which every condition in
+// sla holds, and logs it
+func findMax(ctx context.Context, logger *xlog.Logger, cfg config, sla []condition) error {
+	// Each step runs for a fixed time, with no progress reports of its own
+	cfg.duration = findMaxStepLength
+	cfg.requests = 0
+	cfg.reportInterval = 0
+	cfg.quiet = true
+
+	cfg, err := runSetup(ctx, logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	good, bad := 0, 0
+	for rps := findMaxStartRPS; ; rps *= 2 {
+		if rps > findMaxMaxRPS {
+			rps = findMaxMaxRPS
+		}
+		ok, err := runFindMaxStep(ctx, logger, cfg, rps, sla)
+		if err == errInterrupted {
+			return findMaxInterrupted(logger, good)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			bad = rps
+			break
+		}
+		good = rps
+		if rps == findMaxMaxRPS {
+			logger.Infof("The SLA was met at the maximum rate of %d requests per second", rps)
+			return nil
+		}
+	}
+
+	for bad-good > findMaxResolution {
+		rps := (good + bad) / 2
+		ok, err := runFindMaxStep(ctx, logger, cfg, rps, sla)
+		if err == errInterrupted {
+			return findMaxInterrupted(logger, good)
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			good = rps
+		} else {
+			bad = rps
+		}
+	}
+
+	if good == 0 {
+		logger.Warnf("The SLA was not met at the starting rate of %d requests per second", findMaxStartRPS)
+		return errors.New("no rate met the SLA")
+	}
+	logger.Infof("Highest rate meeting the SLA: %d requests per second", good)
+	return nil
+}
+
+// runFindMaxStep runs a load test at rps, returning true if every condition
+// in sla held. A step stopped by an abort rule does not meet the SLA, nor
+// does one in which the load generator couldn't send requests at rps, as the
+// rate wasn't really tested.
+func runFindMaxStep(ctx context.Context, logger *xlog.Logger, cfg config, rps int, sla []condition) (bool, error) {
+	logger.Infof("Testing %d requests per second for %v", rps, cfg.duration)
+	cfg.rps = rps
+	lt, err := newLoadTest(logger, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	err = lt.run(ctx)
+	if _, ok := err.(abortError); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if lt.interrupted {
+		return false, errInterrupted
+	}
+
+	c := lt.stats.all.counts()
+	if c.sent() == 0 {
+		logger.Infof("  No requests completed")
+		return false, nil
+	}
+
+	met := true
+	if skipped := lt.pool.skippedJobs(); skipped > 0 {
+		logger.Infof("  %d requests were skipped as every worker was busy and the queue full, so the rate wasn't sustained", skipped)
+		met = false
+	}
+	if requested, started := lt.requestedAndStarted(); requested > 0 {
+		if achieved := float64(started) / float64(requested); achieved < sustainedRateThreshold {
+			logger.Infof("  %d of the %d requests due were started (%.0f%%), so the rate wasn't sustained", started, requested, 100*achieved)
+			met = false
+		}
+	}
+
+	latencies := lt.stats.all.latencyHistogram(phaseTotal)
+	for _, cond := range sla {
+		v := cond.value(c, latencies)
+		if cond.holds(v) {
+			logger.Infof("  %s was %s, meeting %q", cond.metric, cond.format(v), cond.text)
+		} else {
+			logger.Infof("  %s was %s, breaking %q", cond.metric, cond.format(v), cond.text)
+			met = false
+		}
+	}
+	return met, nil
+}
+
+// findMaxInterrupted logs the best rate found before find-max was interrupted
+func findMaxInterrupted(logger *xlog.Logger, good int) error {
+	if good == 0 {
+		logger.Infof("Interrupted before any rate met the SLA")
+	} else {
+		logger.Infof("Interrupted: the highest rate to meet the SLA so far was %d requests per second", good)
+	}
+	return nil
+}
+
+func init() {
+	findMaxCmd.Flags().StringArrayVar(&findMaxSLA, "sla", nil, "condition that must hold for a rate to be sustainable, such as \"p99<500ms\" (may be repeated)")
+	findMaxCmd.Flags().IntVar(&findMaxStartRPS, "start-rps", 10, "request rate of the first step")
+	findMaxCmd.Flags().IntVar(&findMaxMaxRPS, "max-rps", 10000, "highest request rate to try")
+	findMaxCmd.Flags().DurationVar(&findMaxStepLength, "step-duration", 30*time.Second, "how long to run each step for")
+	findMaxCmd.Flags().IntVar(&findMaxResolution, "resolution", 10, "stop searching once the highest passing and lowest failing rates are this close")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// formField is a field of a multipart/form-data request body, whose value is
+// either given directly or read from a file
+type formField struct {
+	name  string
+	value string
+	file  string
+}
+
+// parseFormField parses a field of the form "name=value", or "name=@path" to
+// upload the file at path
+func parseFormField(s string) (formField, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return formField{}, fmt.Errorf("invalid form field %q: expected name=value or name=@path", s)
+	}
+	f := formField{name: s[:i], value: s[i+1:]}
+	if strings.HasPrefix(f.value, "@") {
+		f.file, f.value = f.value[1:], ""
+		if _, err := os.Stat(f.file); err != nil {
+			return formField{}, fmt.Errorf("invalid form field %q: %w", s, err)
+		}
+	}
+	return f, nil
+}
+
+// parseFormFields parses the fields given by each of args, which may hold
+// several fields separated by &, as in "a=b&c=d"
+func parseFormFields(args []string) ([]formField, error) {
+	var fields []formField
+	for _, arg := range args {
+		for _, s := range strings.Split(arg, "&") {
+			f, err := parseFormField(s)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// hasFiles returns true if any of fields is a file to upload
+func hasFiles(fields []formField) bool {
+	for _, f := range fields {
+		if f.file != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// newFormBody returns a func which gets a multipart/form-data body of fields,
+// and its content type. Files are streamed into the body as it is read,
+// rather than being held in memory, so each body must be closed once it is
+// no longer needed. Every body has the same boundary, so that it can be sent
+// again with the same content type, such as to answer an auth challenge.
+func newFormBody(fields []formField) (func() (io.ReadCloser, error), string) {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	getBody := func() (io.ReadCloser, error) {
+		r, w := io.Pipe()
+		mw := multipart.NewWriter(w)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return nil, err
+		}
+		go func() {
+			w.CloseWithError(writeForm(mw, fields))
+		}()
+		return r, nil
+	}
+	return getBody, "multipart/form-data; boundary=" + boundary
+}
+
+// writeForm writes fields to mw, and closes it
+func writeForm(mw *multipart.Writer, fields []formField) error {
+	for _, f := range fields {
+		if f.file == "" {
+			if err := mw.WriteField(f.name, f.value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		part, err := mw.CreateFormFile(f.name, filepath.Base(f.file))
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(f.file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
@@ -5,5 +5,6 @@ go 1.16
 require (
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/pflag v1.0.5
+	github.com/spf13/viper v1.7.0
 	github.com/xfxdev/xlog v0.0.0-20190115101715-8752a0193860
 )
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// graphqlRequest is the standard envelope of a GraphQL query sent over HTTP
+type graphqlRequest struct {
+	Query     string          `json:"query"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+}
+
+// readGraphQLBody returns the request body for the GraphQL query in the file
+// at queryPath, with the variables in the JSON file at varsPath, if given
+func readGraphQLBody(queryPath, varsPath string) ([]byte, error) {
+	query, err := os.ReadFile(queryPath)
+	if err != nil {
+		return nil, err
+	}
+	req := graphqlRequest{Query: string(query)}
+	if varsPath != "" {
+		vars, err := os.ReadFile(varsPath)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(vars) {
+			return nil, fmt.Errorf("GraphQL variables in %s are not valid JSON", varsPath)
+		}
+		req.Variables = vars
+	}
+	return json.Marshal(req)
+}
+
+// checkGraphQLResponse returns an error if body is not a GraphQL response,
+// or has any errors. GraphQL servers usually report errors with a 200
+// status, so these would otherwise be counted as OK.
+func checkGraphQLResponse(body []byte) error {
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("invalid GraphQL response: %w", err)
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("GraphQL response has %d errors, the first being %q", len(resp.Errors), resp.Errors[0].Message)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headerRotation is a header whose value is the next of values in each
+// request
+type headerRotation struct {
+	name   string
+	values []string
+}
+
+// parseHeaderRotation parses a rotation of the form "Name=@path", reading the
+// values from the lines of the file at path
+func parseHeaderRotation(s string) (headerRotation, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || !strings.HasPrefix(parts[1], "@") {
+		return headerRotation{}, fmt.Errorf("invalid --header-rotate %q: expected Name=@path", s)
+	}
+
+	values, err := readLines(parts[1][1:])
+	if err != nil {
+		return headerRotation{}, fmt.Errorf("invalid --header-rotate %q: %w", s, err)
+	}
+	if len(values) == 0 {
+		return headerRotation{}, fmt.Errorf("invalid --header-rotate %q: the file contains no values", s)
+	}
+	return headerRotation{name: http.CanonicalHeaderKey(strings.TrimSpace(parts[0])), values: values}, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// histogramSubBuckets is the number of buckets each power of two is split
+	// into, so that a percentile read from a histogram is within 1/128, or
+	// under 1%, of the true value
+	histogramSubBuckets = 128
+	histogramSubBits    = 7
+
+	// histogramBuckets is enough buckets for durations of up to 2^42ns, over
+	// an hour. Longer durations are counted in the last bucket.
+	histogramBuckets = (42-histogramSubBits)*histogramSubBuckets + histogramSubBuckets
+)
+
+// quantiles is a distribution of durations which percentiles can be read
+// from
+type quantiles interface {
+	// count returns the number of durations
+	count() int
+	// percentile returns the pth percentile, where the 100th is the maximum
+	percentile(p float64) time.Duration
+}
+
+// histogram counts durations in logarithmic buckets, so that percentiles can
+// be estimated in constant memory however many durations are recorded. It is
+// safe for concurrent use, and recording doesn't lock.
+type histogram struct {
+	n       int64 // accessed atomically
+	sum     int64 // accessed atomically
+	max     int64 // accessed atomically
+	buckets [histogramBuckets]int64
+}
+
+// histogramBucket returns the index of the bucket counting d. Durations below
+// histogramSubBuckets nanoseconds each have their own bucket, and above that
+// each power of two is split into histogramSubBuckets buckets.
+func histogramBucket(d time.Duration) int {
+	if d < histogramSubBuckets {
+		if d < 0 {
+			return 0
+		}
+		return int(d)
+	}
+	shift := bits.Len64(uint64(d)) - histogramSubBits - 1
+	i := (shift+1)*histogramSubBuckets + int(uint64(d)>>uint(shift)) - histogramSubBuckets
+	if i >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return i
+}
+
+// histogramBucketMax returns the largest duration counted in bucket i
+func histogramBucketMax(i int) time.Duration {
+	if i < histogramSubBuckets {
+		return time.Duration(i)
+	}
+	shift := i/histogramSubBuckets - 1
+	sub := i%histogramSubBuckets + histogramSubBuckets
+	return time.Duration((uint64(sub)+1)<<uint(shift) - 1)
+}
+
+// record adds d to the histogram
+func (h *histogram) record(d time.Duration) {
+	atomic.AddInt64(&h.buckets[histogramBucket(d)], 1)
+	atomic.AddInt64(&h.n, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			return
+		}
+	}
+}
+
+// snapshot returns a copy of the histogram, whose percentiles are consistent
+// with each other while the original carries on recording
+func (h *histogram) snapshot() *histogram {
+	c := &histogram{sum: atomic.LoadInt64(&h.sum), max: atomic.LoadInt64(&h.max)}
+	for i := range h.buckets {
+		c.buckets[i] = atomic.LoadInt64(&h.buckets[i])
+		c.n += c.buckets[i]
+	}
+	return c
+}
+
+// sub returns the durations in the snapshot h which aren't in prev, an earlier
+// snapshot of the same histogram. The maximum is that of h.
+func (h *histogram) sub(prev *histogram) *histogram {
+	d := &histogram{n: h.n - prev.n, sum: h.sum - prev.sum, max: h.max}
+	for i := range h.buckets {
+		d.buckets[i] = h.buckets[i] - prev.buckets[i]
+	}
+	return d
+}
+
+func (h *histogram) count() int {
+	return int(h.n)
+}
+
+// mean returns the exact mean of the durations recorded
+func (h *histogram) mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.n)
+}
+
+// percentile returns the pth percentile using the nearest-rank method, as the
+// largest duration in the bucket it falls in, or the maximum if that is
+// smaller. It should only be called on a snapshot.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := int64(p / 100 * float64(h.n))
+	if float64(rank) < p/100*float64(h.n) || rank == 0 {
+		rank++
+	}
+
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if seen >= rank {
+			if d := histogramBucketMax(i); d < time.Duration(h.max) {
+				return d
+			}
+			break
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// bars returns the durations in the snapshot h counted in n bars of equal
+// width, from the fastest duration to the slowest, along with the slowest
+// duration in each bar. The fastest duration is only known to within its
+// bucket. If every duration is the same, there is a single bar.
+func (h *histogram) bars(n int) ([]time.Duration, []int64) {
+	if h.n == 0 || n <= 0 {
+		return nil, nil
+	}
+	slowest := time.Duration(h.max)
+	fastest := slowest
+	for i, c := range h.buckets {
+		if c > 0 {
+			if d := histogramBucketMax(i); d < fastest {
+				fastest = d
+			}
+			break
+		}
+	}
+	width := (slowest - fastest) / time.Duration(n)
+	if width == 0 {
+		return []time.Duration{slowest}, []int64{h.n}
+	}
+
+	bounds, counts := make([]time.Duration, n), make([]int64, n)
+	for i := range bounds {
+		bounds[i] = fastest + width*time.Duration(i+1)
+	}
+	bounds[n-1] = slowest
+	for i, c := range h.buckets {
+		if c == 0 {
+			continue
+		}
+		j := 0
+		if d := histogramBucketMax(i); d > fastest {
+			j = int((d - fastest - 1) / width)
+		}
+		if j >= n {
+			j = n - 1
+		}
+		counts[j] += c
+	}
+	return bounds, counts
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHistogramBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, 1},
+		{127, 127},
+		{128, 128},
+		{255, 255},
+		{256, 256},
+		{257, 256},
+		{258, 257},
+		{511, 383},
+		{512, 384},
+		{time.Duration(1) << 50, histogramBuckets - 1},
+	}
+	for _, tt := range tests {
+		if got := histogramBucket(tt.d); got != tt.want {
+			t.Errorf("histogramBucket(%d): got %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+// TestHistogramBucketBounds checks that every duration falls in a bucket
+// whose largest duration is at least it, and within 1/128 of it
+func TestHistogramBucketBounds(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, 127, 128, 129, 1000, 4095, 4096, time.Millisecond, 1234567, time.Second, time.Hour} {
+		i := histogramBucket(d)
+		max := histogramBucketMax(i)
+		if max < d {
+			t.Errorf("%v: bucket %d ends at %v, before it", d, i, max)
+		}
+		if float64(max-d) > float64(d)/histogramSubBuckets {
+			t.Errorf("%v: bucket %d ends at %v, more than 1/%d above it", d, i, max, histogramSubBuckets)
+		}
+		if i > 0 && histogramBucketMax(i-1) >= d {
+			t.Errorf("%v: the bucket before %d ends at %v, not before it", d, i, histogramBucketMax(i-1))
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{name: "empty", p: 50, want: 0},
+		{name: "single", durations: []time.Duration{100}, p: 50, want: 100},
+		{name: "exact median", durations: []time.Duration{10, 20, 30, 40}, p: 50, want: 20},
+		{name: "nearest rank", durations: []time.Duration{10, 20, 30, 40, 50}, p: 50, want: 30},
+		{name: "maximum", durations: []time.Duration{10, 20, 30, 40}, p: 100, want: 40},
+		{name: "capped at maximum", durations: []time.Duration{1000, 1001}, p: 100, want: 1001},
+		{name: "lowest", durations: []time.Duration{10, 20, 30, 40}, p: 0, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &histogram{}
+			for _, d := range tt.durations {
+				h.record(d)
+			}
+			if got := h.snapshot().percentile(tt.p); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHistogramPercentileAccuracy checks percentiles against the exact ones
+// of the same durations
+func TestHistogramPercentileAccuracy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	h := &histogram{}
+	durations := make([]time.Duration, 10000)
+	for i := range durations {
+		durations[i] = time.Duration(r.ExpFloat64() * float64(50*time.Millisecond))
+		h.record(durations[i])
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	s := h.snapshot()
+	for _, p := range []float64{50, 90, 95, 99, 99.9} {
+		want := durations[int(math.Ceil(p/100*float64(len(durations))))-1]
+		got := s.percentile(p)
+		if got < want || float64(got-want) > float64(want)/histogramSubBuckets {
+			t.Errorf("p%v: got %v, want %v to within 1/%d", p, got, want, histogramSubBuckets)
+		}
+	}
+	if got, want := s.percentile(100), durations[len(durations)-1]; got != want {
+		t.Errorf("p100: got %v, want %v", got, want)
+	}
+}
+
+func TestHistogramSub(t *testing.T) {
+	h := &histogram{}
+	for _, d := range []time.Duration{10, 20} {
+		h.record(d)
+	}
+	before := h.snapshot()
+	for _, d := range []time.Duration{1000, 2000, 3000} {
+		h.record(d)
+	}
+	delta := h.snapshot().sub(before)
+
+	if got := delta.count(); got != 3 {
+		t.Errorf("count: got %d, want 3", got)
+	}
+	if got := delta.mean(); got != 2000 {
+		t.Errorf("mean: got %v, want 2000ns", got)
+	}
+	if got := delta.percentile(0); got < 1000 || got > 1007 {
+		t.Errorf("lowest: got %v, want 1000ns to within its bucket", got)
+	}
+}
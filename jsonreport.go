@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// jsonReport is the report written by --report json:PATH
+type jsonReport struct {
+	Build      jsonBuild             `json:"build"`
+	Start      time.Time             `json:"start"`
+	Duration   float64               `json:"duration_seconds"` // not counting time paused
+	Paused     float64               `json:"paused_seconds"`
+	Rate       int                   `json:"requests_per_second"` // the peak rate, with a pattern
+	Pattern    string                `json:"pattern,omitempty"`
+	Skipped    int64                 `json:"skipped"`
+	Cancelled  int64                 `json:"cancelled"`
+	CacheMode  string                `json:"cache_mode"`
+	Error      string                `json:"error,omitempty"`
+	Totals     jsonCounts            `json:"totals"`
+	Tags       map[string]jsonCounts `json:"tags,omitempty"`
+	Segments   map[string]jsonCounts `json:"segments,omitempty"`
+	Clients    []jsonClient          `json:"clients,omitempty"`
+	Checks     []notificationCheck   `json:"checks"`
+	TimeSeries []jsonSecond          `json:"time_series"`
+}
+
+type jsonBuild struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+type jsonCounts struct {
+	Requests    int                `json:"requests"`
+	OK          int                `json:"ok"`
+	Failures    int                `json:"failures"`
+	Bytes       int64              `json:"bytes_received"`
+	Expected    int                `json:"expected,omitempty"`
+	NewConns    int                `json:"new_connections"`
+	Conditional int                `json:"conditional,omitempty"`
+	NotModified int                `json:"not_modified,omitempty"`
+	Timeouts    map[string]int     `json:"timeouts,omitempty"`
+	Latencies   map[string]float64 `json:"latency_ms,omitempty"`
+	Apdex       *jsonApdex         `json:"apdex,omitempty"`
+}
+
+// jsonApdex is the Apdex score of a set of requests, if --apdex-t is set
+type jsonApdex struct {
+	Score      float64 `json:"score"`
+	Satisfied  int     `json:"satisfied"`
+	Tolerating int     `json:"tolerating"`
+	Frustrated int     `json:"frustrated"`
+}
+
+// jsonClient is the requests sent by a worker's own client, and the
+// connections it opened, with --client-per-worker
+type jsonClient struct {
+	Requests    int64 `json:"requests"`
+	Connections int64 `json:"connections"`
+}
+
+// jsonSecond is the requests which completed in one second of the load test
+type jsonSecond struct {
+	Second int `json:"second"`
+	jsonCounts
+}
+
+// newJSONCounts returns the counts and latency percentiles of c and the
+// latencies q
+func newJSONCounts(c counts, q quantiles) jsonCounts {
+	jc := jsonCounts{Requests: c.sent(), OK: c.ok, Failures: c.failed, Bytes: c.bytes, Expected: c.expected, NewConns: c.newConns, Conditional: c.conditional, NotModified: c.notModified, Latencies: latencyMillis(q)}
+	for s := stageNone + 1; s < numStages; s++ {
+		if n := c.timeouts[s]; n > 0 {
+			if jc.Timeouts == nil {
+				jc.Timeouts = map[string]int{}
+			}
+			jc.Timeouts[stageNames[s]] = n
+		}
+	}
+	if score, ok := apdexScore(c); ok {
+		jc.Apdex = &jsonApdex{Score: score, Satisfied: c.satisfied, Tolerating: c.tolerating, Frustrated: c.frustrated}
+	}
+	return jc
+}
+
+// writeJSON writes a JSON report of lt, including a time series of each
+// second of the load test, to w
+func writeJSON(w io.Writer, lt *loadTest, err error) error {
+	report := jsonReport{
+		Build:     jsonBuild{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()},
+		Start:     lt.start,
+		Duration:  lt.activeFor().Seconds(),
+		Paused:    lt.pausedFor().Seconds(),
+		Rate:      lt.cfg.rps,
+		CacheMode: lt.cfg.cache.String(),
+		Skipped:   lt.skipped(),
+		Cancelled: lt.cancelled,
+		Totals:    newJSONCounts(lt.stats.all.counts(), lt.stats.all.latencyHistogram(phaseTotal)),
+	}
+	if len(lt.cfg.pattern) > 0 {
+		report.Rate, report.Pattern = lt.cfg.pattern.peak(), lt.cfg.pattern.String()
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if len(lt.stats.tags) > 1 {
+		report.Tags = map[string]jsonCounts{}
+		for _, tag := range lt.stats.tags {
+			s := lt.stats.byTag[tag]
+			report.Tags[tag] = newJSONCounts(s.counts(), s.latencyHistogram(phaseTotal))
+		}
+	}
+	if len(lt.stats.segments) > 0 {
+		report.Segments = map[string]jsonCounts{}
+		for _, segment := range lt.stats.segments {
+			s := lt.stats.bySegment[segment]
+			report.Segments[segment] = newJSONCounts(s.counts(), s.latencyHistogram(phaseTotal))
+		}
+	}
+	for _, wc := range lt.workerClients() {
+		report.Clients = append(report.Clients, jsonClient{Requests: atomic.LoadInt64(&wc.requests), Connections: atomic.LoadInt64(&wc.conns)})
+	}
+	for _, o := range lt.outcomes(err) {
+		report.Checks = append(report.Checks, newNotificationCheck(o))
+	}
+	buckets, first := lt.series.buckets()
+	for i, b := range buckets {
+		report.TimeSeries = append(report.TimeSeries, jsonSecond{Second: first + i, jsonCounts: newJSONCounts(b.counts, b.latencies)})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       float64         `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+// junitProblem is the failure or error of a test case
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnit writes a JUnit XML report of lt to w, so that CI systems can
+// show the outcome of the load test, with a test case for each outcome
+func writeJUnit(w io.Writer, lt *loadTest, err error) error {
+	suite := junitTestSuite{
+		Name:      "slt",
+		Time:      lt.activeFor().Seconds(),
+		Timestamp: lt.start.UTC().Format(time.RFC3339),
+		Properties: []junitProperty{
+			{Name: "version", Value: version},
+			{Name: "commit", Value: commit},
+			{Name: "build_date", Value: buildDate},
+			{Name: "go_version", Value: runtime.Version()},
+			{Name: "requests_per_second", Value: fmt.Sprint(lt.cfg.rps)},
+		},
+	}
+
+	for _, o := range lt.outcomes(err) {
+		tc := junitTestCase{Name: o.name, ClassName: "slt"}
+		if o.group != "" {
+			tc.ClassName += "." + o.group
+		}
+		switch {
+		case o.errored:
+			tc.Error = &junitProblem{Message: o.message}
+			suite.Errors++
+		case !o.passed:
+			tc.Failure = &junitProblem{Message: o.message}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
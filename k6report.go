@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// k6TrendStats are the statistics of each trend metric in a k6 summary, which
+// are k6's defaults
+var k6TrendStats = []string{"avg", "min", "med", "max", "p(90)", "p(95)"}
+
+// k6Phases are the k6 trend metrics written for each phase of a request. k6
+// also times http_req_blocked and http_req_sending, which slt doesn't, and
+// includes zero for a phase that didn't happen, such as connecting on a
+// re-used connection, where slt leaves it out.
+var k6Phases = []struct {
+	metric string
+	phase  phase
+}{
+	{"http_req_connecting", phaseConnect},
+	{"http_req_tls_handshaking", phaseTLS},
+	{"http_req_waiting", phaseTTFB},
+	{"http_req_receiving", phaseBody},
+	{"http_req_duration", phaseTotal},
+}
+
+// k6Summary is the report written by --report k6-summary:PATH, in the schema
+// of the summary k6 passes to handleSummary, so that tools and dashboards
+// built for k6 results can read it
+type k6Summary struct {
+	RootGroup k6Group             `json:"root_group"`
+	Options   k6Options           `json:"options"`
+	State     k6State             `json:"state"`
+	Metrics   map[string]k6Metric `json:"metrics"`
+}
+
+type k6Group struct {
+	Name   string    `json:"name"`
+	Path   string    `json:"path"`
+	ID     string    `json:"id"`
+	Groups []k6Group `json:"groups"`
+	Checks []k6Check `json:"checks"`
+}
+
+type k6Check struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	ID     string `json:"id"`
+	Passes int    `json:"passes"`
+	Fails  int    `json:"fails"`
+}
+
+type k6Options struct {
+	SummaryTrendStats []string `json:"summaryTrendStats"`
+	SummaryTimeUnit   string   `json:"summaryTimeUnit"`
+	NoColor           bool     `json:"noColor"`
+}
+
+type k6State struct {
+	IsStdOutTTY       bool    `json:"isStdOutTTY"`
+	IsStdErrTTY       bool    `json:"isStdErrTTY"`
+	TestRunDurationMs float64 `json:"testRunDurationMs"`
+}
+
+// k6Metric is a metric in a k6 summary, whose type is counter, rate or trend
+type k6Metric struct {
+	Type     string             `json:"type"`
+	Contains string             `json:"contains"`
+	Values   map[string]float64 `json:"values"`
+}
+
+// k6ID returns the ID k6 gives the group or check at path
+func k6ID(path string) string {
+	sum := md5.Sum([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+// k6Counter returns a counter metric of n over elapsed
+func k6Counter(n float64, contains string, elapsed time.Duration) k6Metric {
+	return k6Metric{Type: "counter", Contains: contains, Values: map[string]float64{"count": n, "rate": n / elapsed.Seconds()}}
+}
+
+// k6Rate returns a rate metric of passes out of total
+func k6Rate(passes, total int) k6Metric {
+	m := k6Metric{Type: "rate", Contains: "default", Values: map[string]float64{"passes": float64(passes), "fails": float64(total - passes), "rate": 0}}
+	if total > 0 {
+		m.Values["rate"] = float64(passes) / float64(total)
+	}
+	return m
+}
+
+// k6Trend returns a trend metric of the durations in h, in milliseconds
+func k6Trend(h *histogram) k6Metric {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return k6Metric{Type: "trend", Contains: "time", Values: map[string]float64{
+		"avg":   ms(h.mean()),
+		"min":   ms(h.percentile(0)),
+		"med":   ms(h.percentile(50)),
+		"max":   ms(h.percentile(100)),
+		"p(90)": ms(h.percentile(90)),
+		"p(95)": ms(h.percentile(95)),
+	}}
+}
+
+// writeK6Summary writes a k6 summary of lt to w. Each request counts as an
+// iteration, and the checks are the outcomes of the load test.
+func writeK6Summary(w io.Writer, lt *loadTest, err error) error {
+	elapsed := lt.activeFor()
+	c := lt.stats.all.counts()
+
+	summary := k6Summary{
+		RootGroup: k6Group{ID: k6ID(""), Groups: []k6Group{}, Checks: []k6Check{}},
+		Options:   k6Options{SummaryTrendStats: k6TrendStats},
+		State: k6State{
+			IsStdOutTTY:       isTerminal(os.Stdout),
+			IsStdErrTTY:       isTerminal(os.Stderr),
+			TestRunDurationMs: float64(elapsed) / float64(time.Millisecond),
+		},
+		Metrics: map[string]k6Metric{
+			"http_reqs":       k6Counter(float64(c.sent()), "default", elapsed),
+			"iterations":      k6Counter(float64(c.sent()), "default", elapsed),
+			"data_received":   k6Counter(float64(c.bytes), "data", elapsed),
+			"http_req_failed": k6Rate(c.failed, c.sent()),
+		},
+	}
+
+	passed, total := 0, 0
+	for _, o := range lt.outcomes(err) {
+		name := o.name
+		if o.group != "" {
+			name = o.group + ": " + name
+		}
+		check := k6Check{Name: name, Path: "::" + name, ID: k6ID("::" + name)}
+		if o.passed {
+			check.Passes = 1
+			passed++
+		} else {
+			check.Fails = 1
+		}
+		total++
+		summary.RootGroup.Checks = append(summary.RootGroup.Checks, check)
+	}
+	summary.Metrics["checks"] = k6Rate(passed, total)
+
+	for _, p := range k6Phases {
+		if h := lt.stats.all.latencyHistogram(p.phase); h.count() > 0 {
+			summary.Metrics[p.metric] = k6Trend(h)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// latencyChartWidth is the number of characters in the longest bar of a
+// latency histogram
+const latencyChartWidth = 40
+
+// logLatencyChart logs the latencies in h as a bar chart of n bars, like hey
+// does, with each bar labelled with the slowest latency it counts
+func logLatencyChart(logger *xlog.Logger, h *histogram, n int) {
+	bounds, counts := h.bars(n)
+	if len(bounds) == 0 {
+		return
+	}
+
+	var labels []string
+	var labelWidth, countWidth int
+	var most int64
+	for i, d := range bounds {
+		labels = append(labels, d.Round(time.Microsecond).String())
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+		if w := len(strconv.FormatInt(counts[i], 10)); w > countWidth {
+			countWidth = w
+		}
+		if counts[i] > most {
+			most = counts[i]
+		}
+	}
+
+	logger.Infof("Latency histogram:")
+	for i, label := range labels {
+		bar := strings.Repeat("#", int(counts[i]*latencyChartWidth/most))
+		logger.Infof("  %*s [%*d] |%s", labelWidth, label, countWidth, counts[i], bar)
+	}
+}
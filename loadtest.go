@@ -0,0 +1,1003 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// result describes the outcome of a single request
+type result struct {
+	id        string // the request ID sent with --request-id-header, if any
+	tag       string
+	segment   string
+	method    string
+	url       string
+	code      int // the response's status code
+	ok        bool
+	err       string // why the request failed, if it did
+	timedOut  stage  // the stage the request timed out in, if it did
+	expected  bool   // true if the response had one of the --expect-codes
+	apdex     apdexZone
+	newConn   bool // true if a new connection was opened for the request
+	condition bool // true if the request was conditional, with --revalidate
+	unchanged bool // true if a conditional request got 304 Not Modified
+	redirects int
+	bytesOut  int64 // of the request body, if its length was known
+	bytes     int64 // of the response body that was read
+	timings   timings
+}
+
+// loadTest holds the state of a running load test
+type loadTest struct {
+	ctx         context.Context // cancelled once the load test has finished
+	reqCtx      context.Context // of the requests, cancelled after they are drained
+	cfg         config
+	logger      *xlog.Logger
+	start       time.Time
+	client      *http.Client
+	dns         *dnsResolver
+	signer      *sigV4Signer    // nil unless --aws-sigv4 is set
+	validators  *validatorStore // nil unless --revalidate is set
+	targets     []target
+	nextTarget  *rotator
+	nextUA      *rotator
+	nextHeaders []*rotator // for each of the header rotations
+	stats       *taggedStats
+	series      timeSeries
+	results     []*resultLog    // for each --out and --raw-results
+	trace       *traceLog       // nil unless --trace-log is set
+	monitor     *selfMonitor    // nil unless soak testing
+	aborter     *abortMonitor   // nil unless there are abort rules
+	checker     *checkMonitor   // nil unless there are named checks
+	controller  *rateController // nil unless the rate is adaptive
+	pool        *workerPool
+	clients     []*workerClient // one per worker, with --client-per-worker
+	clientsMu   sync.Mutex
+	fatal       chan error
+	done        chan struct{} // closed once the request limit is reached
+	finish      sync.Once     // closes done
+	interrupted bool          // true if the load test was interrupted, such as by a signal
+	dispatched  int64         // number of requests started, accessed atomically
+	inFlight    int64         // number of requests being sent, accessed atomically
+	cancelled   int64         // requests cancelled as they were in flight after draining
+	cacheBusts  int64         // number of requests given a unique --cache-bust value, accessed atomically
+	rps         int64         // target request rate, or the pattern's peak rate, accessed atomically
+	requested   schedule
+	target      targetClock
+	paused      int32 // non-zero while no requests are being sent, accessed atomically
+	pauses      int64 // number of times paused, accessed atomically
+	resumed     chan struct{}
+	pauseMu     sync.Mutex
+	pausedAt    time.Time     // when the current pause started, zero if not paused
+	pausedTotal time.Duration // spent paused before the current pause
+	stoppedAt   time.Time     // when requests stopped being sent, zero until then
+}
+
+func sendRequests(ctx context.Context, logger *xlog.Logger, cfg config) error {
+	for _, t := range cfg.targets {
+		logger.Infof("Starting load test to %s", t.url)
+	}
+	if len(cfg.pattern) > 0 {
+		logger.Infof("Sending requests in the pattern %s, peaking at %d requests per second", cfg.pattern, cfg.pattern.peak())
+	} else {
+		logger.Infof("Sending %d requests per second", cfg.rps)
+	}
+	if cfg.throttle > 0 {
+		logger.Infof("Throttling each connection to %s in each direction", cfg.throttle)
+	}
+	if cfg.spike.interval > 0 {
+		logger.Infof("Spiking to %d requests per second for %v every %v", int(float64(cfg.rps)*cfg.spike.multiplier), cfg.spike.length, cfg.spike.interval)
+	}
+
+	cfg, err := runSetup(ctx, logger, cfg)
+	if err != nil {
+		return err
+	}
+	lt, err := newLoadTest(logger, cfg)
+	if err != nil {
+		xlog.Error(err)
+		return err
+	}
+
+	err = lt.run(ctx)
+	lt.summary()
+	lt.writeReports(err)
+	if cfg.notifyURL != "" {
+		if notifyErr := lt.notify(err); notifyErr != nil {
+			logger.Errorf("Unable to send notification to %s: %s", cfg.notifyURL, notifyErr)
+		}
+	}
+	if _, ok := err.(abortError); err != nil && !ok {
+		logger.Fatal(err)
+	}
+	return err
+}
+
+// run sends requests until the load test finishes, is aborted, or ctx is
+// cancelled, returning any fatal error or an abortError. Cancelling ctx
+// interrupts the load test. However it ends, the requests in flight are
+// drained before run returns.
+func (lt *loadTest) run(ctx context.Context) error {
+	cfg, logger := lt.cfg, lt.logger
+	lt.start = time.Now()
+	defer lt.closeIdleConnections()
+
+	// Everything started below stops when the load test's context is
+	// cancelled, except for the requests still in flight, which have their
+	// own context so that they can be drained
+	var cancel, cancelRequests context.CancelFunc
+	lt.ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	lt.reqCtx, cancelRequests = context.WithCancel(context.Background())
+	defer cancelRequests()
+	if cfg.duration > 0 {
+		go lt.stopAfter(cfg.duration, cancel)
+	}
+	lt.watchPauseSignals()
+
+	// The control API is started first, so that a bad address fails the load
+	// test before any requests are sent
+	if cfg.controlAddr != "" {
+		l, err := net.Listen("tcp", cfg.controlAddr)
+		if err != nil {
+			return fmt.Errorf("unable to start the control API: %w", err)
+		}
+		// It is stopped before returning, so that the address is free for
+		// the next load test, such as the next step of find-max
+		defer lt.startControlServer(l)()
+		logger.Infof("Control API listening on %s", l.Addr())
+	}
+
+	for _, rf := range cfg.results {
+		rl, err := newResultLog(rf)
+		if err != nil {
+			return fmt.Errorf("unable to write raw results: %w", err)
+		}
+		lt.results = append(lt.results, rl)
+		defer func(rf resultFile) {
+			if err := rl.close(); err != nil {
+				logger.Errorf("Unable to write %s results to %s: %s", rf.format, rf.path, err)
+			}
+		}(rf)
+	}
+
+	if cfg.traceLog != "" {
+		trace, err := newTraceLog(cfg.traceLog, cfg.traceSample)
+		if err != nil {
+			return fmt.Errorf("unable to write the trace log: %w", err)
+		}
+		lt.trace = trace
+		defer func() {
+			if err := trace.close(); err != nil {
+				logger.Errorf("Unable to write the trace log to %s: %s", cfg.traceLog, err)
+			}
+		}()
+	}
+
+	// Thread to check the abort rules
+	var aborted chan abortError
+	if len(cfg.abortRules) > 0 {
+		lt.aborter = newAbortMonitor(cfg.abortRules)
+		aborted = make(chan abortError, 1)
+		go lt.aborter.run(aborted, lt.ctx.Done())
+	}
+
+	// Thread to evaluate the named checks
+	if lt.checker != nil {
+		go lt.checker.run(lt.ctx.Done())
+	}
+
+	// Thread to adjust the rate based on latency
+	if cfg.adaptiveP99 > 0 {
+		lt.controller = newRateController(cfg.adaptiveP99, cfg.rps, cfg.minRPS)
+		go lt.controller.run(lt.start, cfg.adaptiveInterval, logger, lt.ctx.Done())
+	}
+
+	// Thread to monitor the load generator itself
+	if cfg.soak {
+		lt.monitor = newSelfMonitor(logger)
+		go lt.monitor.run(cfg.soakInterval, lt.ctx.Done())
+	}
+
+	// Thread to push metrics to the sinks, which is waited for so that the
+	// final push isn't lost
+	if len(cfg.sinks) > 0 {
+		var sinks []sink
+		for _, spec := range cfg.sinks {
+			s, err := spec.new()
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, s)
+		}
+		pushed := make(chan struct{})
+		go lt.runSinks(sinks, cfg.sinkInterval, lt.ctx.Done(), pushed)
+		defer func() { <-pushed }()
+	}
+
+	// Thread to keep memory under the limit
+	if cfg.maxMemory > 0 {
+		g := &memoryGuard{lt: lt, limit: cfg.maxMemory}
+		go g.run(lt.ctx.Done())
+	}
+
+	// Thread to print data about the requests. Bounded runs show a progress
+	// bar instead when writing to a terminal.
+	var bar *progressBar
+	if cfg.bounded() && !cfg.quiet && isTerminal(os.Stdout) {
+		bar = newProgressBar(lt, os.Stdout)
+		go bar.run()
+	} else if cfg.reportInterval > 0 {
+		go newReporter(lt, logger).run(cfg.reportInterval, lt.ctx.Done())
+	}
+
+	lt.pool = newWorkerPool(lt, cfg.workers, cfg.maxQueue)
+	if cfg.workers > 0 {
+		logger.Debugf("Using %d workers", cfg.workers)
+	} else {
+		logger.Debugf("Using %d workers to start with, adding more as needed", lt.pool.workers())
+	}
+
+	// Thread to make requests, the first second of which starts now, as does
+	// the first after the load test is resumed
+	timer := time.NewTimer(0)
+	go func(logger *xlog.Logger, timer *time.Timer) {
+		for {
+			// wait for the timer to fire
+			select {
+			case <-timer.C:
+			case <-lt.resumed:
+				if !timer.Stop() {
+					<-timer.C
+				}
+			case <-lt.ctx.Done():
+				return
+			}
+
+			rps, segment := lt.rate(time.Since(lt.start))
+			lt.requested.add(time.Now(), rps, cfg.burst)
+			if !cfg.burst {
+				go lt.pace(rps, segment)
+				timer.Reset(time.Second) // Reset the timer so it fires again
+				continue
+			}
+
+			// Send all of this second's requests at once
+			go func(j job) {
+				for i := 0; i < rps; i++ {
+					lt.pool.submit(j)
+				}
+			}(job{segment: segment, scheduled: time.Now()})
+			timer.Reset(time.Second) // Reset the timer so it fires again
+		}
+	}(logger, timer)
+
+	// Run until a request fails fatally, the duration is up or we are asked to
+	// stop
+	var e error
+	select {
+	case e = <-lt.fatal:
+	case <-lt.ctx.Done():
+		if ctx.Err() != nil {
+			logger.Infof("Interrupted, stopping")
+			lt.interrupted = true
+		}
+	case <-lt.done:
+	case a := <-aborted:
+		logger.Warnf("Aborting load test: %s", a.reason)
+		e = a
+	}
+	timer.Stop() // Stop the timer
+
+	// Stop sending requests, so that no more are due while draining, and
+	// give those in flight until the end of the drain window to complete
+	cancel()
+	lt.stopped()
+	lt.requested.stop(time.Now())
+	lt.drain(cancelRequests)
+
+	if bar != nil {
+		bar.stop()
+	}
+
+	return e
+}
+
+// newLoadTest returns a load test for cfg, with the client and requests ready
+// to send
+func newLoadTest(logger *xlog.Logger, cfg config) (*loadTest, error) {
+	lt := &loadTest{
+		ctx:        context.Background(),
+		reqCtx:     context.Background(),
+		cfg:        cfg,
+		logger:     logger,
+		start:      time.Now(),
+		dns:        newDNSResolver(cfg.dnsTTL),
+		nextTarget: newRotator(len(cfg.targets)),
+		nextUA:     newRotator(len(cfg.userAgents)),
+		rps:        int64(cfg.rps),
+		fatal:      make(chan error, 1),
+		done:       make(chan struct{}),
+		resumed:    make(chan struct{}, 1),
+	}
+	if len(cfg.pattern) > 0 {
+		lt.rps = int64(cfg.pattern.peak())
+	}
+	lt.target.rps = cfg.rps
+	lt.client = newClient(cfg, lt.dns)
+	// Idle connections are closed whenever a host's addresses change, so
+	// that new connections are made to the new addresses
+	lt.dns.onChange = lt.closeIdleConnections
+	if cfg.awsSigV4.region != "" {
+		signer, err := newSigV4Signer(cfg.awsSigV4)
+		if err != nil {
+			return nil, err
+		}
+		lt.signer = signer
+	}
+	if cfg.cache.revalidate {
+		lt.validators = newValidatorStore()
+	}
+	if len(cfg.checks) > 0 {
+		lt.checker = newCheckMonitor(logger, cfg.checks)
+	}
+
+	// Build the requests for re-use
+	var tags []string
+	var weights []int // of every target, if they are weighted
+	for _, t := range cfg.targets {
+		method := t.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req, err := http.NewRequest(method, t.url, bytes.NewReader(t.body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", cfg.userAgent)
+		for key, val := range cfg.headers {
+			req.Header.Set(key, val)
+		}
+		for key, vals := range t.headers {
+			req.Header[key] = vals
+		}
+		t.req = req
+		lt.targets = append(lt.targets, t)
+		tags = append(tags, t.tag)
+		if t.weight > 0 {
+			weights = append(weights, t.weight)
+		}
+	}
+	var segments []string
+	if cfg.spike.interval > 0 {
+		segments = []string{segmentBaseline, segmentSpike}
+	}
+	if len(cfg.pattern) > 0 {
+		segments = cfg.pattern.segments()
+	}
+	lt.stats = newTaggedStats(tags, segments)
+	for _, h := range cfg.headerRotations {
+		lt.nextHeaders = append(lt.nextHeaders, newRotator(len(h.values)))
+	}
+	if len(weights) > 0 {
+		lt.nextTarget = newWeightedRotator(weights)
+	}
+
+	return lt, nil
+}
+
+// summaryLogger returns the logger to log the summary of a load test to,
+// which logs at Info level even with --quiet
+func summaryLogger(logger *xlog.Logger, cfg config) *xlog.Logger {
+	if cfg.quiet {
+		return xlog.New(xlog.InfoLevel, os.Stdout, logLayout)
+	}
+	return logger
+}
+
+// summary logs the final statistics of the load test. The summary is shown
+// even when other output is suppressed by --quiet.
+func (lt *loadTest) summary() {
+	logger := summaryLogger(lt.logger, lt.cfg)
+
+	if paused := lt.pausedFor(); paused > 0 {
+		logger.Infof("Summary after %v, of which %v was paused:", time.Since(lt.start).Round(time.Millisecond), paused.Round(time.Millisecond))
+	} else {
+		logger.Infof("Summary after %v:", time.Since(lt.start).Round(time.Millisecond))
+	}
+	logger.Infof("Generated by %s", buildInfo())
+	if !lt.cfg.cache.isDefault() {
+		logger.Infof("Cache mode: %s", lt.cfg.cache.describe())
+	}
+	newReporter(lt, logger).report()
+	if lt.cfg.histogramBars > 0 {
+		logLatencyChart(logger, lt.stats.all.latencyHistogram(phaseTotal), lt.cfg.histogramBars)
+	}
+	lt.logConnections(logger)
+	lt.logClients(logger)
+	lt.logCancelled(logger)
+	lt.logTimeouts(logger)
+	if lt.cfg.cache.revalidate {
+		lt.logRevalidation(logger)
+	}
+	if len(lt.cfg.expectCodes) > 0 {
+		lt.logExpected(logger)
+	}
+	if lt.cfg.apdex.satisfied > 0 {
+		lt.logApdex(logger)
+	}
+	if lt.checker != nil {
+		lt.checker.logChecks(logger)
+	}
+	if lt.monitor != nil {
+		lt.monitor.logPeak(logger)
+	}
+	if lt.controller != nil {
+		lt.controller.logTimeline(logger)
+	}
+	lt.dns.log(logger)
+	if lt.cfg.split {
+		lt.logComparison(logger)
+	}
+}
+
+// rate returns the number of requests to send in the second starting at
+// elapsed after the load test started, and the segment of the load test that
+// they belong to. A pattern's rates are scaled by how far the target rate
+// has been changed from its peak, such as by --max-memory. No requests are
+// sent while the load test is paused.
+func (lt *loadTest) rate(elapsed time.Duration) (int, string) {
+	cfg := lt.cfg
+	rps := int(atomic.LoadInt64(&lt.rps))
+	if lt.controller != nil {
+		rps = lt.controller.current()
+	}
+	var segment string
+	if len(cfg.pattern) > 0 {
+		target := rps
+		rps, segment = cfg.pattern.at(elapsed)
+		if peak := cfg.pattern.peak(); target != peak {
+			rps = int(int64(rps) * int64(target) / int64(peak))
+		}
+	}
+	if lt.isPaused() {
+		rps = 0
+	}
+
+	if cfg.spike.interval == 0 {
+		return rps, segment
+	}
+	if cfg.spike.active(elapsed) {
+		return int(float64(rps) * cfg.spike.multiplier), segmentSpike
+	}
+	return rps, segmentBaseline
+}
+
+// setRate changes the target request rate of the running load test
+func (lt *loadTest) setRate(rps int) {
+	atomic.StoreInt64(&lt.rps, int64(rps))
+	lt.target.set(lt.activeFor(), rps)
+	if lt.controller != nil {
+		lt.controller.setTarget(rps, time.Since(lt.start))
+	}
+	if len(lt.cfg.pattern) > 0 {
+		lt.logger.Infof("Changed the pattern's peak rate to %d requests per second", rps)
+	} else {
+		lt.logger.Infof("Changed the rate to %d requests per second", rps)
+	}
+}
+
+// setPaused pauses or resumes sending requests. Open connections are kept,
+// and time spent paused doesn't count towards the duration of the load test
+// or the rates reported.
+func (lt *loadTest) setPaused(paused bool) {
+	lt.pauseMu.Lock()
+	defer lt.pauseMu.Unlock()
+	if paused == lt.isPaused() {
+		return
+	}
+
+	if paused {
+		lt.pausedAt = time.Now()
+		atomic.StoreInt32(&lt.paused, 1)
+		atomic.AddInt64(&lt.pauses, 1)
+		lt.requested.stop(lt.pausedAt)
+		lt.logger.Infof("Paused")
+	} else {
+		d := time.Since(lt.pausedAt)
+		lt.pausedTotal += d
+		lt.pausedAt = time.Time{}
+		atomic.StoreInt32(&lt.paused, 0)
+		select {
+		case lt.resumed <- struct{}{}:
+		default:
+		}
+		lt.logger.Infof("Resumed after %v", d.Round(time.Millisecond))
+	}
+}
+
+// isPaused returns true if the load test is paused
+func (lt *loadTest) isPaused() bool {
+	return atomic.LoadInt32(&lt.paused) != 0
+}
+
+// pace sends n requests spread evenly over the next second, so that the
+// target sees a steady arrival rate rather than a burst. It stops if the
+// load test is paused, even if it is resumed within the second, as resuming
+// starts a new second.
+func (lt *loadTest) pace(n int, segment string) {
+	if n <= 0 {
+		return
+	}
+
+	pauses := atomic.LoadInt64(&lt.pauses)
+	start := time.Now()
+	gap := time.Second / time.Duration(n)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := 0; i < n; i++ {
+		// Schedule from the start of the second, so that delays don't add up
+		scheduled := start.Add(time.Duration(i) * gap)
+		timer.Reset(time.Until(scheduled))
+		select {
+		case <-timer.C:
+		case <-lt.ctx.Done():
+			return
+		}
+		if lt.isPaused() || atomic.LoadInt64(&lt.pauses) != pauses {
+			return
+		}
+
+		lt.pool.submit(job{segment: segment, scheduled: scheduled})
+	}
+}
+
+// send sends a single request to the next target with the worker's client,
+// or the shared client if wc is nil, unless the request limit has been
+// reached
+func (lt *loadTest) send(wc *workerClient, j job) {
+	if !lt.claim() {
+		return
+	}
+	client := lt.client
+	if wc != nil {
+		atomic.AddInt64(&wc.requests, 1)
+		client = wc.client
+	}
+	lt.sendRequest(client, lt.targets[lt.nextTarget.next()], j)
+}
+
+// skipped returns the number of requests which were due to be sent, but
+// were skipped as the load generator was saturated
+func (lt *loadTest) skipped() int64 {
+	if lt.pool == nil {
+		return 0
+	}
+	return lt.pool.skippedJobs()
+}
+
+// requestedAndStarted returns the number of requests that the target rate
+// called for by now, and the number that have been started. A steady rate
+// calls for requests at that rate over the time the load test has been
+// active, while the requests for a rate which varies, such as a --pattern,
+// are as they were scheduled each second.
+func (lt *loadTest) requestedAndStarted() (int64, int64) {
+	requested := lt.requested.due(time.Now())
+	if len(lt.cfg.pattern) == 0 && lt.cfg.spike.interval == 0 && lt.controller == nil {
+		requested = lt.target.at(lt.activeFor())
+	}
+	started := atomic.LoadInt64(&lt.dispatched)
+	if limit := int64(lt.cfg.requests); limit > 0 {
+		// Requests beyond the limit are scheduled, but never started
+		if requested > limit {
+			requested = limit
+		}
+		if started > limit {
+			started = limit
+		}
+	}
+	return requested, started
+}
+
+// claim reserves a request from the request limit, returning false if the
+// limit has been reached
+func (lt *loadTest) claim() bool {
+	n := atomic.AddInt64(&lt.dispatched, 1)
+	return lt.cfg.requests <= 0 || n <= int64(lt.cfg.requests)
+}
+
+// sendRequest sends a single request to target t for job j, recording its
+// result in the job's segment. If a list of User-Agents was given, the
+// request is sent with the next one in the rotation.
+//
+// As well as the time the request took, the time since it was scheduled is
+// recorded as its intended latency. This includes any time spent waiting for
+// a worker, so slow responses which delay later requests don't hide the
+// latency that a real client sending at the requested rate would see.
+//
+// A request which times out is counted as a failure, along with the stage it
+// timed out in. Any other error sending the request stops the load test.
+func (lt *loadTest) sendRequest(client *http.Client, t target, j job) {
+	// The request is counted as in flight before checking that the load
+	// test hasn't finished, so that draining can't miss it
+	atomic.AddInt64(&lt.inFlight, 1)
+	defer atomic.AddInt64(&lt.inFlight, -1)
+	if lt.ctx.Err() != nil {
+		return
+	}
+
+	tr := newTracer()
+	ctx, cancel := context.WithTimeout(httptrace.WithClientTrace(lt.reqCtx, tr.clientTrace()), lt.cfg.timeout)
+	defer cancel()
+
+	req := lt.newRequest(ctx, t)
+	var condition bool
+	if lt.validators != nil {
+		condition = lt.validators.apply(req)
+	}
+	var id string
+	if lt.cfg.requestIDHeader != "" {
+		id = newRequestID()
+		req.Header.Set(lt.cfg.requestIDHeader, id)
+	}
+	err := lt.sign(req)
+	var tc *traceCapture
+	if lt.trace != nil && lt.trace.sample() {
+		tc = newTraceCapture(req)
+	}
+
+	var resp *http.Response
+	if err == nil {
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		if lt.reqCtx.Err() != nil {
+			// The request was cancelled as it was still in flight after
+			// the load test finished
+			return
+		}
+		if isTimeout(err) {
+			// Timeouts are counted as failures, by the stage they happened in
+			s := tr.stage()
+			err = timeoutError(s, err)
+			r := result{id: id, tag: t.tag, segment: j.segment, method: req.Method, url: req.URL.String(), err: err.Error(), timedOut: s, condition: condition, timings: tr.finish()}
+			r.timings[phaseIntended] = time.Since(j.scheduled)
+			r.apdex = lt.cfg.apdex.zone(r)
+			if id != "" {
+				lt.logger.Debugf("Request %s failed: %s", id, err)
+			} else {
+				lt.logger.Debugf("Request failed: %s", err)
+			}
+			if tc != nil {
+				lt.trace.write(tc.entry(nil, r, err))
+			}
+			lt.record(r)
+			return
+		}
+		if tc != nil {
+			lt.trace.write(tc.entry(nil, result{id: id, tag: t.tag, timings: tr.finish()}, err))
+		}
+		if id != "" {
+			err = fmt.Errorf("request %s: %w", id, err)
+		}
+		// Only the first fatal error is reported
+		select {
+		case lt.fatal <- err:
+		default:
+		}
+		return
+	}
+
+	if tc != nil {
+		tc.response(resp)
+	}
+	if lt.validators != nil {
+		lt.validators.store(req, resp)
+	}
+
+	// GraphQL responses are always read in full, so that they can be checked
+	var body []byte
+	var size int64
+	var readErr error
+	switch {
+	case lt.cfg.graphql:
+		body, readErr = io.ReadAll(resp.Body)
+		size = int64(len(body))
+	case lt.cfg.readBody == bodyFull:
+		size, readErr = io.Copy(io.Discard, resp.Body)
+	}
+	tm := tr.finish()
+	if lt.cfg.readBody == bodyDiscard && !lt.cfg.graphql {
+		// A drained body lets the connection be re-used, but the time it
+		// takes isn't part of the latency
+		size, _ = io.Copy(io.Discard, resp.Body)
+	}
+	resp.Body.Close()
+
+	r := result{id: id, tag: t.tag, segment: j.segment, redirects: countRedirects(resp), bytes: size, timings: tm, newConn: tr.openedConn(), condition: condition}
+	// A 304 Not Modified is the point of a conditional request, so it is OK
+	// whatever the --ok-codes
+	r.unchanged = condition && resp.StatusCode == http.StatusNotModified
+	r.timings[phaseIntended] = time.Since(j.scheduled)
+	err = readErr
+	if err != nil && isTimeout(err) {
+		r.timedOut = stageBody
+		err = timeoutError(stageBody, err)
+	}
+	if err == nil && isExpectedCode(resp.StatusCode, lt.cfg.expectCodes) {
+		r.expected = true
+	} else if err == nil && !r.unchanged {
+		err = checkResponse(resp, lt.cfg.okCodes, lt.cfg.expectHeaders)
+		if err == nil && lt.cfg.graphql {
+			err = checkGraphQLResponse(body)
+		}
+	}
+	r.method, r.url, r.code = req.Method, req.URL.String(), resp.StatusCode
+	if req.ContentLength > 0 {
+		r.bytesOut = req.ContentLength
+	}
+	if err != nil {
+		r.err = err.Error()
+	}
+	if err != nil && id != "" {
+		lt.logger.Debugf("Request %s failed: %s", id, err)
+	} else if err != nil {
+		lt.logger.Debugf("Request failed: %s", err)
+	} else {
+		r.ok = true
+	}
+	r.apdex = lt.cfg.apdex.zone(r)
+	if tc != nil {
+		lt.trace.write(tc.entry(resp, r, err))
+	}
+
+	lt.record(r)
+}
+
+// record adds the result of a request to the statistics, unless the
+// requests in flight have already been drained. Results are recorded by the
+// worker which sent the request, so every part of the statistics must be
+// safe for concurrent use.
+func (lt *loadTest) record(r result) {
+	if lt.reqCtx.Err() != nil {
+		return
+	}
+
+	elapsed := time.Since(lt.start)
+	lt.stats.record(r)
+	lt.series.record(elapsed, r)
+	for _, rl := range lt.results {
+		rl.write(lt.start, elapsed, r)
+	}
+	if lt.aborter != nil {
+		lt.aborter.observe(r)
+	}
+	if lt.checker != nil {
+		lt.checker.observe(r)
+	}
+	if lt.controller != nil {
+		lt.controller.observe(r)
+	}
+	if limit := lt.cfg.requests; limit > 0 && lt.stats.all.counts().sent() >= limit {
+		lt.finish.Do(func() { close(lt.done) })
+	}
+}
+
+// newRequest returns a copy of t's request with its own context and body, so
+// that it can be sent concurrently with other requests to t
+func (lt *loadTest) newRequest(ctx context.Context, t target) *http.Request {
+	req := t.req.Clone(ctx)
+	if len(lt.cfg.form) > 0 {
+		// The form is streamed, so its length isn't known up front
+		var contentType string
+		req.GetBody, contentType = newFormBody(lt.cfg.form)
+		req.Body, _ = req.GetBody()
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", contentType)
+	} else if t.req.GetBody != nil {
+		req.Body, _ = t.req.GetBody()
+	}
+	if len(lt.cfg.userAgents) > 0 {
+		req.Header.Set("User-Agent", lt.cfg.userAgents[lt.nextUA.next()])
+	}
+	for i, h := range lt.cfg.headerRotations {
+		req.Header.Set(h.name, h.values[lt.nextHeaders[i].next()])
+	}
+	// The placeholders of a --mix entry are replaced before --query-random
+	// re-encodes the query, which would escape them
+	for _, p := range t.params {
+		placeholder, v := "{{"+p.name+"}}", p.value()
+		if strings.Contains(req.URL.Path, placeholder) {
+			req.URL.Path = strings.Replace(req.URL.Path, placeholder, v, 1)
+			req.URL.RawPath = ""
+		} else {
+			req.URL.RawQuery = strings.Replace(req.URL.RawQuery, placeholder, url.QueryEscape(v), 1)
+		}
+	}
+	if len(lt.cfg.randomParams) > 0 {
+		addRandomParams(req.URL, lt.cfg.randomParams)
+	}
+	if lt.cfg.cache.bust {
+		lt.bustCache(req)
+	}
+	return req
+}
+
+// sign signs req if --aws-sigv4 is set. It must be called after every other
+// change to the request.
+func (lt *loadTest) sign(req *http.Request) error {
+	if lt.signer == nil {
+		return nil
+	}
+	if err := lt.signer.sign(req); err != nil {
+		return fmt.Errorf("unable to sign request: %w", err)
+	}
+	return nil
+}
+
+// countRedirects returns the number of redirects that were followed to
+// produce the given response
+func countRedirects(resp *http.Response) int {
+	n := 0
+	for r := resp.Request; r != nil && r.Response != nil; r = r.Response.Request {
+		n++
+	}
+	return n
+}
+
+// schedule counts the requests which were due to be sent by a given time,
+// as the request rate changes each second. It is safe for concurrent use.
+type schedule struct {
+	mu       sync.Mutex
+	previous int64     // due in earlier seconds
+	start    time.Time // when the current second started
+	n        int       // to send in the current second
+	burst    bool      // true if the current second's requests were all due at its start
+}
+
+// add starts a new second at start, in which n requests are to be sent
+func (s *schedule) add(start time.Time, n int, burst bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previous += int64(s.n)
+	s.start, s.n, s.burst = start, n, burst
+}
+
+// current returns the number of requests to be sent in the current second
+func (s *schedule) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// due returns the number of requests which were due to be sent by now
+func (s *schedule) due(now time.Time) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dueLocked(now)
+}
+
+// stop ends the current second at now, such as when the load test is paused,
+// so that none of its remaining requests become due
+func (s *schedule) stop(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.previous, s.n = s.dueLocked(now), 0
+}
+
+// dueLocked is due for a caller holding s.mu
+func (s *schedule) dueLocked(now time.Time) int64 {
+	if s.n == 0 || s.burst {
+		return s.previous + int64(s.n)
+	}
+
+	// Requests are spread evenly over the second, the first at its start
+	n := int(now.Sub(s.start)/(time.Second/time.Duration(s.n))) + 1
+	if n > s.n {
+		n = s.n
+	}
+	return s.previous + int64(n)
+}
+
+// targetClock counts the requests that a steady target rate calls for over
+// the time a load test has been active, as the rate is changed. It is safe
+// for concurrent use.
+type targetClock struct {
+	mu     sync.Mutex
+	rps    int
+	since  time.Duration // the active time when the rate was last changed
+	before float64       // requests called for before then
+}
+
+// set changes the rate to rps, after the load test has been active for
+// active
+func (c *targetClock) set(active time.Duration, rps int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.before = c.atLocked(active)
+	c.since, c.rps = active, rps
+}
+
+// at returns the number of requests called for once the load test has been
+// active for active
+func (c *targetClock) at(active time.Duration) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int64(c.atLocked(active))
+}
+
+// atLocked is at for a caller holding c.mu
+func (c *targetClock) atLocked(active time.Duration) float64 {
+	return c.before + float64(c.rps)*(active-c.since).Seconds()
+}
+
+// rotator cycles through the indexes of a list. It is safe for concurrent use.
+type rotator struct {
+	size  uint64
+	order []int // the indexes in the order they are returned, if weighted
+	n     uint64
+}
+
+// newRotator returns a rotator over a list of size items
+func newRotator(size int) *rotator {
+	return &rotator{size: uint64(size)}
+}
+
+// newWeightedRotator returns a rotator over a list of items with the given
+// weights, which returns each index in proportion to its weight. The indexes
+// are interleaved as evenly as possible, using smooth weighted round robin.
+func newWeightedRotator(weights []int) *rotator {
+	g, total := 0, 0
+	for _, w := range weights {
+		g = gcd(g, w)
+	}
+	for i := range weights {
+		total += weights[i] / g
+	}
+
+	var order []int
+	current := make([]int, len(weights))
+	for n := 0; n < total; n++ {
+		best := 0
+		for i, w := range weights {
+			current[i] += w / g
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		order = append(order, best)
+	}
+	return &rotator{size: uint64(len(order)), order: order}
+}
+
+// next returns the next index in the rotation
+func (r *rotator) next() int {
+	i := int((atomic.AddUint64(&r.n, 1) - 1) % r.size)
+	if r.order != nil {
+		return r.order[i]
+	}
+	return i
+}
+
+// gcd returns the greatest common divisor of a and b
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWeightedRotator(t *testing.T) {
+	tests := []struct {
+		weights []int
+		want    []int
+	}{
+		{weights: []int{1}, want: []int{0}},
+		{weights: []int{1, 1, 1}, want: []int{0, 1, 2}},
+		{weights: []int{2, 1}, want: []int{0, 1, 0}},
+		{weights: []int{70, 30}, want: []int{0, 1, 0, 0, 0, 1, 0, 0, 1, 0}},
+		{weights: []int{5, 1, 1}, want: []int{0, 0, 1, 0, 2, 0, 0}},
+		{weights: []int{4, 2, 6}, want: []int{2, 0, 1, 2, 0, 2}},
+	}
+	for _, tt := range tests {
+		r := newWeightedRotator(tt.weights)
+		var got []int
+		for i := 0; i < 2*len(tt.want); i++ {
+			got = append(got, r.next())
+		}
+		want := append(append([]int{}, tt.want...), tt.want...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("weights %v: got %v, want %v", tt.weights, got, want)
+		}
+	}
+}
+
+func TestWeightedRotatorShares(t *testing.T) {
+	weights := []int{70, 25, 5}
+	r := newWeightedRotator(weights)
+	counts := make([]int, len(weights))
+	for i := 0; i < 1000; i++ {
+		counts[r.next()]++
+	}
+	for i, w := range weights {
+		if counts[i] != w*10 {
+			t.Errorf("index %d: got %d of 1000, want %d", i, counts[i], w*10)
+		}
+	}
+}
+
+func TestRotator(t *testing.T) {
+	r := newRotator(3)
+	var got []int
+	for i := 0; i < 7; i++ {
+		got = append(got, r.next())
+	}
+	if want := []int{0, 1, 2, 0, 1, 2, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
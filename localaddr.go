@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxLocalAddrs is the most addresses a --local-addr-range may contain
+const maxLocalAddrs = 65536
+
+// parseAddrRange parses a range of IP addresses of the form "FIRST-LAST",
+// such as "10.0.0.10-10.0.0.50", returning every address in the range
+func parseAddrRange(s string) ([]net.IP, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid address range %q: expected FIRST-LAST", s)
+	}
+	first, last := net.ParseIP(parts[0]), net.ParseIP(parts[1])
+	if first == nil || last == nil {
+		return nil, fmt.Errorf("invalid address range %q: expected two IP addresses", s)
+	}
+	if v4 := first.To4(); v4 != nil {
+		first = v4
+	}
+	if v4 := last.To4(); v4 != nil {
+		last = v4
+	}
+	if len(first) != len(last) || bytes.Compare(first, last) > 0 {
+		return nil, fmt.Errorf("invalid address range %q: the first address must come before the last", s)
+	}
+
+	var addrs []net.IP
+	for ip := first; ; {
+		if len(addrs) == maxLocalAddrs {
+			return nil, fmt.Errorf("invalid address range %q: more than %d addresses", s, maxLocalAddrs)
+		}
+		addrs = append(addrs, ip)
+		if ip.Equal(last) {
+			return addrs, nil
+		}
+		var ok bool
+		if ip, ok = nextIP(ip); !ok {
+			return nil, fmt.Errorf("invalid address range %q: runs past the end of the address space", s)
+		}
+	}
+}
+
+// nextIP returns the address after ip, or false if ip is the last address
+func nextIP(ip net.IP) (net.IP, bool) {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next, true
+		}
+	}
+	return nil, false
+}
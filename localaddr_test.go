@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseAddrRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "10.0.0.1-10.0.0.1", want: []string{"10.0.0.1"}},
+		{in: "10.0.0.1-10.0.0.3", want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{in: "10.0.0.254-10.0.1.1", want: []string{"10.0.0.254", "10.0.0.255", "10.0.1.0", "10.0.1.1"}},
+		{in: "fd00::fffe-fd00::1:0", want: []string{"fd00::fffe", "fd00::ffff", "fd00::1:0"}},
+		{in: "255.255.255.254-255.255.255.255", want: []string{"255.255.255.254", "255.255.255.255"}},
+		{in: "10.0.0.3-10.0.0.1", wantErr: true},
+		{in: "10.0.0.1", wantErr: true},
+		{in: "10.0.0.1-", wantErr: true},
+		{in: "10.0.0.1-example.com", wantErr: true},
+		{in: "10.0.0.1-fd00::1", wantErr: true},
+		{in: "10.0.0.0-10.1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseAddrRange(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i, ip := range got {
+				if !ip.Equal(net.ParseIP(tt.want[i])) {
+					t.Errorf("address %d: got %v, want %v", i, ip, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNextIP(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{in: "10.0.0.1", want: "10.0.0.2", wantOK: true},
+		{in: "10.0.0.255", want: "10.0.1.0", wantOK: true},
+		{in: "255.255.255.255"},
+		{in: "::ffff", want: "::1:0", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			ip := net.ParseIP(tt.in)
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			got, ok := nextIP(ip)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
-	"net/http"
-	"net/url"
+	"fmt"
+	"math/rand"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -12,146 +18,768 @@ import (
 	"github.com/xfxdev/xlog"
 )
 
-const (
-	maxRequestsPerThread = 20
-)
+// logLayout is the layout of all log lines
+const logLayout = "%L %l"
 
 var (
-	debug             bool
-	headers           map[string]string
-	okCodes           []int
-	requestsPerSecond int
-	timeoutSeconds    int
+	abortOn             []string
+	adaptiveInterval    time.Duration
+	adaptiveP99         time.Duration
+	apdexFrustrated     time.Duration
+	apdexT              time.Duration
+	awsSigV4            string
+	burst               bool
+	cacheBust           string
+	cacheOnly           bool
+	clientPerWorker     bool
+	configFile          string
+	connectTimeout      time.Duration
+	controlAddr         string
+	debug               bool
+	digestAuth          string
+	disableKeepAlive    bool
+	dnsCache            bool
+	dnsTTL              time.Duration
+	drain               time.Duration
+	dryRun              bool
+	duration            time.Duration
+	expectCodes         []int
+	expectHeaders       []string
+	extract             []string
+	followRedirects     bool
+	form                []string
+	graphqlQuery        string
+	graphqlVars         string
+	headers             map[string]string
+	headerRotate        []string
+	headerTimeout       time.Duration
+	histogramBars       int
+	jsonBody            string
+	localAddr           string
+	localAddrRange      string
+	idleConnTimeout     time.Duration
+	logLevel            string
+	maxConnsPerHost     int
+	maxIdleConnsPerHost int
+	maxMemory           string
+	maxQueue            int
+	maxRedirects        int
+	mix                 string
+	minRPS              int
+	notifyURL           string
+	okCodes             []string
+	outputs             []string
+	patternSpec         string
+	plotFile            string
+	probe               bool
+	queryRandom         []string
+	quiet               bool
+	rawResults          string
+	readBody            string
+	reportInterval      time.Duration
+	reports             []string
+	requestIDHeader     string
+	setupFile           string
+	sinkInterval        time.Duration
+	sinks               []string
+	sni                 string
+	requests            int
+	requestsPerSecond   int
+	revalidate          bool
+	soak                bool
+	soakInterval        time.Duration
+	spikeSpec           string
+	spikeInterval       time.Duration
+	split               string
+	targetsFile         string
+	tcpNoDelay          bool
+	thinkTimeSpec       string
+	throttleSpec        string
+	tlsCiphers          []string
+	traceLogFile        string
+	traceSample         string
+	tlsMaxVersion       string
+	tlsMinVersion       string
+	timeoutSeconds      int
+	totalTimeout        time.Duration
+	userAgent           string
+	userAgentFile       string
+	workers             int
 )
 
+// config holds the settings for a single load test run
+type config struct {
+	targets             []target
+	split               bool    // true if the targets are compared with the first
+	setup               *target // sent once before the load test, if set
+	extractions         []extraction
+	headers             map[string]string
+	headerRotations     []headerRotation
+	form                []formField
+	randomParams        []randomParam
+	graphql             bool // true if responses are checked for GraphQL errors
+	okCodes             statusCodes
+	expectCodes         []int // counted as OK, and tracked as the expected outcome
+	expectHeaders       []headerExpectation
+	readBody            bodyMode
+	cache               cacheMode
+	apdex               apdexThresholds
+	rps                 int
+	burst               bool
+	workers             int
+	clientPerWorker     bool // true if each worker has its own client
+	maxQueue            int  // most requests waiting for a worker, or 0 for a second's worth
+	duration            time.Duration
+	drain               time.Duration // to wait for requests in flight when the load test ends
+	requests            int
+	spike               spike
+	pattern             pattern // sets the rate instead of rps, if not empty
+	abortRules          []abortRule
+	checks              []namedCheck // from the config file
+	adaptiveP99         time.Duration
+	adaptiveInterval    time.Duration
+	minRPS              int
+	reportInterval      time.Duration
+	histogramBars       int // in the summary's latency histogram, or 0 for none
+	reports             []reportFile
+	results             []resultFile // to stream every result to
+	traceLog            string       // file to write sampled requests to as NDJSON, if set
+	traceSample         float64
+	notifyURL           string
+	sinks               []sinkSpec
+	sinkInterval        time.Duration
+	controlAddr         string
+	quiet               bool
+	soak                bool
+	soakInterval        time.Duration
+	maxMemory           uint64        // heap size above which memory is shed, or 0 for no limit
+	timeout             time.Duration // for each request as a whole
+	connectTimeout      time.Duration
+	headerTimeout       time.Duration // waiting for the response headers, or 0 for no limit
+	thinkTime           thinkTime
+	followRedirects     bool
+	maxRedirects        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+	disableKeepAlive    bool          // true if every request opens a new connection
+	dnsTTL              time.Duration // how long resolved addresses are cached, or 0 to resolve for every connection
+	tcpNoDelay          bool
+	throttle            bandwidth // of each connection in each direction, or 0 for no limit
+	localAddrs          []net.IP  // to send requests from, in turn, if set
+	tlsMinVersion       uint16
+	tlsMaxVersion       uint16
+	tlsCiphers          []uint16
+	sni                 string // the TLS server name to send, instead of the URL's host
+	userAgent           string
+	userAgents          []string
+	requestIDHeader     string
+	awsSigV4            awsScope          // to sign requests for, if the region is set
+	digestAuth          digestCredentials // to answer Digest challenges with, if the user is set
+}
+
+// bounded returns true if the load test stops after a set duration or number
+// of requests
+func (c config) bounded() bool {
+	return c.duration > 0 || c.requests > 0
+}
+
 var rootCmd = &cobra.Command{
-	Use:   "slt",
+	Use:   "slt [flags] [tag=]URL...",
 	Short: "Run a simple load test",
-	Long:  "Run a simple load test against a given endpoint",
-	Args: func(cmd *cobra.Command, args []string) error {
-		if len(args) != 1 {
-			return errors.New("expected 1 URL")
-		}
+	Long: `Run a simple load test against one or more endpoints.
+
+When more than one URL is given, requests are sent to each in turn. Each URL
+may be prefixed with a tag, such as "login=https://example.com/login", and
+statistics are reported for each tag as well as overall. With --split, the
+load is divided between URLs by weight instead, and the summary compares each
+URL with the first, such as a canary with the current deployment.
+
+Targets may also be read from a file, or from stdin with "--targets-file -",
+with one target per line in the form "METHOD [tag=]URL [weight=N]". Each
+target may be followed by "Name: value" header lines and an "@path" line
+naming a file to send as the request body. If any target has a weight, the
+requests are divided between them in proportion, such as 9 reads to 1 write.
 
-		_, err := url.Parse(args[0])
+Every flag may also be set with an SLT_ environment variable, such as
+SLT_REQUESTS_PER_SECOND, or in a YAML config file, which is read from
+slt.yaml in the current directory unless --config is given. Flags take
+precedence over environment variables, which take precedence over the config
+file. URLs may be given with SLT_TARGETS or "targets" in the config file when
+none are given on the command line. In the config file, each target may also
+be a map with its own url, tag, method, headers, body or body_file, and
+weight.
+
+The config file may also define named "checks", each a condition such as
+"p99<500ms" or "error_rate<1%" that must hold for the requests to its tags
+and segments. Checks are evaluated every second while the load test runs,
+and whether each passed over the whole load test is shown in the summary and
+every report.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger, cfg, err := setup(cmd, args)
 		if err != nil {
-			return errors.New("unable to parse argument to a valid URL")
+			return err
 		}
 
-		return nil
-	},
-	RunE: func(cmd *cobra.Command, args []string) error {
-		logLevel := xlog.InfoLevel
-		if debug {
-			logLevel = xlog.DebugLevel
+		if dryRun {
+			return runDryRun(cmd.Context(), logger, cfg, probe)
 		}
-		logger := xlog.New(logLevel, os.Stdout, "%L %l")
-		return sendRequests(logger, args[0], headers, okCodes, requestsPerSecond, timeoutSeconds)
+		return sendRequests(cmd.Context(), logger, cfg)
 	},
 }
 
-func sendRequests(logger *xlog.Logger, url string, headers map[string]string, okCodes []int, rps, timeout int) error {
-	logger.Infof("Starting load test to %s", url)
-	logger.Infof("Sending %d requests per second", rps)
+// setup reads the settings for cmd from its flags, the environment and the
+// config file, returning the logger and config for a load test of the
+// targets in args
+func setup(cmd *cobra.Command, args []string) (*xlog.Logger, config, error) {
+	configTargets, checks, err := loadConfigFile(cmd.Flags(), configFile)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return nil, config{}, err
+	}
+	targets := configTargets
+	if len(args) > 0 {
+		targets = nil
+		for _, arg := range args {
+			t, err := parseTarget(arg)
+			if err != nil {
+				return nil, config{}, err
+			}
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 && targetsFile == "" && split == "" {
+		return nil, config{}, errors.New("expected at least 1 URL, --targets-file or --split")
+	}
+
+	// The arguments are valid, so don't show the usage for any later errors
+	cmd.SilenceUsage = true
 
-	h := http.DefaultClient
-	h.Timeout = time.Second * time.Duration(timeout)
+	level, ok := xlog.ParseLevel(logLevel)
+	if !ok {
+		return nil, config{}, fmt.Errorf("unknown log level %q", logLevel)
+	}
+	if debug {
+		level = xlog.DebugLevel
+	}
+	if quiet {
+		if level > xlog.WarnLevel {
+			level = xlog.WarnLevel
+		}
+		reportInterval = 0
+	}
+	logger := xlog.New(level, os.Stdout, logLayout)
 
-	var okCount, errCount int
-	var responses = make(chan bool)
-	var fatal = make(chan error)
+	cfg, err := buildConfig(targets, checks)
+	if err != nil {
+		return nil, config{}, err
+	}
+	return logger, cfg, nil
+}
 
-	// Thread to count the responses
-	go func(responses chan bool) {
-		for r := range responses {
-			if r {
-				okCount++
-			} else {
-				errCount++
-			}
+// buildConfig builds the config for a load test of the given targets, along
+// with any from --targets-file, from the command line flags
+func buildConfig(given []target, checks []namedCheck) (config, error) {
+	var userAgents []string
+	if userAgentFile != "" {
+		var err error
+		userAgents, err = readLines(userAgentFile)
+		if err != nil {
+			return config{}, err
+		}
+		if len(userAgents) == 0 {
+			return config{}, errors.New("user agent file contains no user agents")
 		}
-	}(responses)
+	}
+
+	var rotations []headerRotation
+	for _, h := range headerRotate {
+		rotation, err := parseHeaderRotation(h)
+		if err != nil {
+			return config{}, err
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	var expectations []headerExpectation
+	for _, e := range expectHeaders {
+		expectation, err := parseHeaderExpectation(e)
+		if err != nil {
+			return config{}, err
+		}
+		expectations = append(expectations, expectation)
+	}
 
-	// Thread to print data about the requests
-	go func(logger *xlog.Logger) {
-		for {
-			logger.Infof("Sent %d requests, %d ok, %d failures", okCount+errCount, okCount, errCount)
-			time.Sleep(5 * time.Second)
+	var targets []target
+	if targetsFile != "" {
+		var err error
+		targets, err = readTargetsFile(targetsFile)
+		if err != nil {
+			return config{}, fmt.Errorf("unable to read targets: %w", err)
+		}
+	}
+	targets = append(targets, given...)
+	weighTargets(targets)
+	if split != "" {
+		if len(targets) > 0 {
+			return config{}, errors.New("--split can't be used with other URLs or --targets-file")
+		}
+		var err error
+		targets, err = parseSplit(split)
+		if err != nil {
+			return config{}, err
 		}
-	}(logger)
+	}
+	if len(targets) == 0 {
+		return config{}, errors.New("no targets to send requests to")
+	}
 
-	// Build the request for re-use
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	var fields []formField
+	var err error
+	if mix != "" {
+		if len(targets) != 1 || split != "" {
+			return config{}, errors.New("--mix requires exactly one base URL, such as https://api.example.com, and can't be used with --split or --targets-file")
+		}
+		if targets, err = parseMix(mix, targets[0]); err != nil {
+			return config{}, err
+		}
+		targets, err = applyMixBodyFlags(targets)
+	} else {
+		targets, fields, err = applyBodyFlags(targets)
+	}
 	if err != nil {
-		xlog.Error(err)
-		return err
+		return config{}, err
 	}
 
-	for key, val := range headers {
-		req.Header.Add(key, val)
+	var localAddrs []net.IP
+	if localAddr != "" && localAddrRange != "" {
+		return config{}, errors.New("only one of --local-addr and --local-addr-range may be given")
+	}
+	if localAddr != "" {
+		ip := net.ParseIP(localAddr)
+		if ip == nil {
+			return config{}, fmt.Errorf("invalid local address %q: expected an IP address", localAddr)
+		}
+		localAddrs = []net.IP{ip}
+	}
+	if localAddrRange != "" {
+		var err error
+		localAddrs, err = parseAddrRange(localAddrRange)
+		if err != nil {
+			return config{}, err
+		}
 	}
 
-	numThreads := (rps / maxRequestsPerThread) + 1
-	logger.Debugf("Using %d threads, with maximum %d requests per thread (maximum %d per second)", numThreads, maxRequestsPerThread, numThreads*maxRequestsPerThread)
+	minVersion, err := parseTLSVersion(tlsMinVersion)
+	if err != nil {
+		return config{}, err
+	}
+	maxVersion, err := parseTLSVersion(tlsMaxVersion)
+	if err != nil {
+		return config{}, err
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return config{}, errors.New("--tls-min-version must not be above --tls-max-version")
+	}
+	ciphers, err := parseCipherSuites(tlsCiphers)
+	if err != nil {
+		return config{}, err
+	}
 
-	// Thread to make requests
-	timer := time.NewTimer(time.Second)
-	go func(logger *xlog.Logger, timer *time.Timer) {
-		for {
-			<-timer.C // wait for the timer to fire
+	var params []randomParam
+	for _, q := range queryRandom {
+		p, err := parseRandomParam(q)
+		if err != nil {
+			return config{}, err
+		}
+		params = append(params, p)
+	}
 
-			// Send each request in its own thread
-			for i := 0; i < numThreads; i++ {
-				reqsForThisThread := rps % ((i + 1) * maxRequestsPerThread)
-				if reqsForThisThread > maxRequestsPerThread {
-					reqsForThisThread = maxRequestsPerThread
-				}
+	var rules []abortRule
+	for _, a := range abortOn {
+		rule, err := parseAbortRule(a)
+		if err != nil {
+			return config{}, err
+		}
+		rules = append(rules, rule)
+	}
 
-				go sendNRequests(logger, h, req, okCodes, responses, fatal, reqsForThisThread)
-			}
-			timer.Reset(time.Second) // Reset the timer so it fires again
+	var setupTarget *target
+	if setupFile != "" {
+		setupTargets, err := readTargetsFile(setupFile)
+		if err != nil {
+			return config{}, fmt.Errorf("unable to read the setup request: %w", err)
+		}
+		if len(setupTargets) != 1 {
+			return config{}, fmt.Errorf("setup file must contain exactly one request, not %d", len(setupTargets))
+		}
+		setupTarget = &setupTargets[0]
+	}
+	var extractions []extraction
+	for _, e := range extract {
+		x, err := parseExtraction(e)
+		if err != nil {
+			return config{}, err
 		}
-	}(logger, timer)
+		extractions = append(extractions, x)
+	}
+	if len(extractions) > 0 && setupTarget == nil {
+		return config{}, errors.New("--extract requires --setup-file")
+	}
 
-	e := <-fatal
-	logger.Fatal(e)
-	timer.Stop() // Stop the timer
-	return e
-}
+	var think thinkTime
+	if thinkTimeSpec != "" {
+		var err error
+		think, err = parseThinkTime(thinkTimeSpec)
+		if err != nil {
+			return config{}, err
+		}
+	}
+
+	ttl := dnsTTL
+	if !dnsCache {
+		ttl = 0
+	} else if ttl <= 0 {
+		return config{}, errors.New("--dns-ttl must be positive, use --dns-cache=false to resolve for every connection")
+	}
+
+	codes, err := parseStatusCodes(okCodes)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid --ok-codes: %w", err)
+	}
+
+	readMode, err := parseBodyMode(readBody)
+	if err != nil {
+		return config{}, err
+	}
+
+	var reportFiles []reportFile
+	for _, r := range reports {
+		rf, err := parseReportFile(r)
+		if err != nil {
+			return config{}, err
+		}
+		reportFiles = append(reportFiles, rf)
+	}
+	if plotFile != "" {
+		reportFiles = append(reportFiles, reportFile{format: "plot", path: plotFile})
+	}
+
+	if maxQueue < 0 {
+		return config{}, errors.New("--max-queue must not be negative")
+	}
+	if drain < 0 {
+		return config{}, errors.New("--drain must not be negative")
+	}
+	if histogramBars < 0 {
+		return config{}, errors.New("--histogram-bars must not be negative")
+	}
 
-func sendNRequests(logger *xlog.Logger, h *http.Client, req *http.Request, okCodes []int, responses chan bool, fatal chan error, n int) {
-	logger.Debugf("Sending %d requests in thread", n)
-	for i := 0; i < n; i++ {
-		sendRequest(logger, h, req, okCodes, responses, fatal)
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if totalTimeout > 0 {
+		timeout = totalTimeout
 	}
+	if timeout <= 0 || connectTimeout <= 0 || headerTimeout < 0 {
+		return config{}, errors.New("timeouts must be positive")
+	}
+
+	var resultFiles []resultFile
+	for _, o := range outputs {
+		rf, err := parseResultFile(o)
+		if err != nil {
+			return config{}, err
+		}
+		resultFiles = append(resultFiles, rf)
+	}
+	if rawResults != "" {
+		resultFiles = append(resultFiles, resultFile{format: "csv", path: rawResults})
+	}
+
+	var sinkSpecs []sinkSpec
+	for _, s := range sinks {
+		spec, err := parseSinkSpec(s)
+		if err != nil {
+			return config{}, err
+		}
+		sinkSpecs = append(sinkSpecs, spec)
+	}
+	if len(sinkSpecs) > 0 && sinkInterval <= 0 {
+		return config{}, errors.New("--sink-interval must be positive")
+	}
+
+	cache, err := parseCacheMode(cacheBust, cacheOnly, revalidate)
+	if err != nil {
+		return config{}, err
+	}
+
+	apdex, err := newApdexThresholds(apdexT, apdexFrustrated)
+	if err != nil {
+		return config{}, err
+	}
+
+	var scope awsScope
+	if awsSigV4 != "" {
+		scope, err = parseAWSScope(awsSigV4)
+		if err != nil {
+			return config{}, err
+		}
+	}
+
+	var digest digestCredentials
+	if digestAuth != "" {
+		if awsSigV4 != "" {
+			return config{}, errors.New("--digest-auth and --aws-sigv4 can't be used together, as both set the Authorization header")
+		}
+		digest, err = parseDigestCredentials(digestAuth)
+		if err != nil {
+			return config{}, err
+		}
+	}
+
+	sample, err := parseTraceSample(traceSample)
+	if err != nil {
+		return config{}, err
+	}
+
+	var throttle bandwidth
+	if throttleSpec != "" {
+		throttle, err = parseBandwidth(throttleSpec)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid --throttle: %w", err)
+		}
+	}
+
+	var memLimit uint64
+	if maxMemory != "" {
+		memLimit, err = parseSize(maxMemory)
+		if err != nil {
+			return config{}, fmt.Errorf("invalid --max-memory: %w", err)
+		}
+	}
+
+	var s spike
+	if spikeSpec != "" {
+		var err error
+		s, err = parseSpike(spikeSpec, spikeInterval)
+		if err != nil {
+			return config{}, err
+		}
+	}
+
+	var p pattern
+	if patternSpec != "" {
+		if spikeSpec != "" || adaptiveP99 > 0 {
+			return config{}, errors.New("--pattern can't be used with --spike or --adaptive-p99, as it sets the request rate")
+		}
+		var err error
+		p, err = parsePattern(patternSpec)
+		if err != nil {
+			return config{}, err
+		}
+		if len(p.segments()) == 0 {
+			return config{}, errors.New("--pattern sends no requests")
+		}
+	}
+
+	cfg := config{
+		targets:             targets,
+		split:               split != "",
+		setup:               setupTarget,
+		extractions:         extractions,
+		headers:             headers,
+		headerRotations:     rotations,
+		form:                fields,
+		randomParams:        params,
+		graphql:             graphqlQuery != "",
+		okCodes:             codes,
+		expectCodes:         expectCodes,
+		expectHeaders:       expectations,
+		readBody:            readMode,
+		cache:               cache,
+		apdex:               apdex,
+		rps:                 requestsPerSecond,
+		burst:               burst,
+		workers:             workers,
+		clientPerWorker:     clientPerWorker,
+		maxQueue:            maxQueue,
+		duration:            duration,
+		drain:               drain,
+		requests:            requests,
+		spike:               s,
+		pattern:             p,
+		abortRules:          rules,
+		checks:              checks,
+		adaptiveP99:         adaptiveP99,
+		adaptiveInterval:    adaptiveInterval,
+		minRPS:              minRPS,
+		reportInterval:      reportInterval,
+		histogramBars:       histogramBars,
+		reports:             reportFiles,
+		results:             resultFiles,
+		traceLog:            traceLogFile,
+		traceSample:         sample,
+		notifyURL:           notifyURL,
+		sinks:               sinkSpecs,
+		sinkInterval:        sinkInterval,
+		controlAddr:         controlAddr,
+		quiet:               quiet,
+		soak:                soak,
+		soakInterval:        soakInterval,
+		maxMemory:           memLimit,
+		timeout:             timeout,
+		connectTimeout:      connectTimeout,
+		headerTimeout:       headerTimeout,
+		thinkTime:           think,
+		followRedirects:     followRedirects,
+		maxRedirects:        maxRedirects,
+		maxIdleConnsPerHost: maxIdleConnsPerHost,
+		maxConnsPerHost:     maxConnsPerHost,
+		idleConnTimeout:     idleConnTimeout,
+		dnsTTL:              ttl,
+		tcpNoDelay:          tcpNoDelay,
+		throttle:            throttle,
+		disableKeepAlive:    disableKeepAlive,
+		localAddrs:          localAddrs,
+		tlsMinVersion:       minVersion,
+		tlsMaxVersion:       maxVersion,
+		tlsCiphers:          ciphers,
+		sni:                 sni,
+		userAgent:           userAgent,
+		userAgents:          userAgents,
+		requestIDHeader:     requestIDHeader,
+		awsSigV4:            scope,
+		digestAuth:          digest,
+	}
+	if cfg.cache.only {
+		if err := checkCacheOnly(cfg); err != nil {
+			return config{}, err
+		}
+	}
+	if err := checkNamedChecks(cfg); err != nil {
+		return config{}, err
+	}
+	return cfg, nil
 }
 
-// sendRequest sends a single request
-func sendRequest(logger *xlog.Logger, h *http.Client, req *http.Request, okCodes []int, responses chan bool, fatal chan error) {
-	resp, err := h.Do(req)
+// readLines reads the non-empty lines from the file at path, ignoring any
+// lines starting with #
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		fatal <- err
+		return nil, err
 	}
-	resp.Body.Close()
+	defer f.Close()
 
-	for _, c := range okCodes {
-		if c == resp.StatusCode {
-			responses <- true
-			return
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		lines = append(lines, line)
 	}
-	responses <- false
-	logger.Debugf("Request failed with code %q", resp.Status)
+	return lines, scanner.Err()
 }
 
 func main() {
-	rootCmd.Execute()
+	rand.Seed(time.Now().UnixNano())
+
+	// Interrupting slt stops the load test early, still showing the summary
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		os.Exit(1)
+	}
 }
 
 func init() {
-	pflag.BoolVarP(&debug, "debug", "v", false, "enable verbose logging")
+	rootCmd.AddCommand(findMaxCmd, scheduleCmd, sseCmd, versionCmd)
+
+	pflag.DurationVar(&adaptiveP99, "adaptive-p99", 0, "back off the request rate while p99 latency exceeds this, ramping back up when it recovers")
+	pflag.DurationVar(&adaptiveInterval, "adaptive-interval", 5*time.Second, "how often to adjust the request rate when --adaptive-p99 is set")
+	pflag.IntVar(&minRPS, "min-rps", 1, "lowest request rate to back off to when --adaptive-p99 is set")
+	pflag.StringArrayVar(&abortOn, "abort-on", nil, "stop the load test early if a condition holds for a duration, such as \"error_rate>50%:30s\" or \"p99>2s:1m\" (may be repeated)")
+	pflag.BoolVar(&burst, "burst", false, "send each second's requests all at once, instead of spreading them evenly over the second")
+	pflag.StringVar(&configFile, "config", "", "config file to read settings from (default \"slt.yaml\" if it exists)")
+	pflag.StringVar(&controlAddr, "control-addr", "", "address to serve a web UI and HTTP API on to watch, pause, resume and change the rate of the running load test, such as \":8089\"")
+	pflag.BoolVarP(&debug, "debug", "v", false, "enable verbose logging, equivalent to --log-level debug")
+	pflag.StringVar(&logLevel, "log-level", "info", "minimum level of log messages to show, one of debug, info, warn or error")
+	pflag.BoolVarP(&quiet, "quiet", "q", false, "only show warnings, errors and the final summary")
+	pflag.BoolVar(&dryRun, "dry-run", false, "validate the configuration and show what would be sent, without generating any load")
+	pflag.BoolVar(&probe, "probe", false, "with --dry-run, send a single probe request to each URL")
 	pflag.IntVarP(&requestsPerSecond, "requests-per-second", "r", 1, "approximate number of requests to make per second")
+	pflag.DurationVarP(&duration, "duration", "d", 0, "how long to run the load test for, or 0 to run until interrupted")
+	pflag.IntVarP(&requests, "requests", "n", 0, "total number of requests to send, or 0 to send requests until interrupted")
+	pflag.DurationVar(&drain, "drain", 0, "how long to wait for requests in flight to complete when the load test ends, however it ends, before cancelling them")
+	pflag.DurationVar(&reportInterval, "report-interval", 5*time.Second, "how often to report progress, or 0 to disable progress reports")
+	pflag.IntVar(&histogramBars, "histogram-bars", 10, "number of bars in the latency histogram shown in the summary, or 0 to not show it")
+	pflag.StringArrayVar(&reports, "report", nil, "write a report when the load test finishes, as FORMAT:PATH where FORMAT is csv, json, junit, k6-summary or plot, such as \"junit:results.xml\" (may be repeated)")
+	pflag.StringVar(&plotFile, "plot", "", "write an HTML plot of the latency of each request over time to the file, the same as --report plot:FILE")
+	pflag.StringArrayVar(&outputs, "out", nil, "stream the result of every request to a file as FORMAT:PATH, where FORMAT is csv, or vegeta or vegeta-json for vegeta's report, plot and hist commands, such as \"vegeta:results.bin\" (may be repeated)")
+	pflag.StringVar(&rawResults, "raw-results", "", "stream the result of every request to the file as CSV, for analysis afterwards, the same as --out csv:FILE")
+	pflag.StringVar(&traceLogFile, "trace-log", "", "write the headers, bodies (truncated) and timings of a sample of requests to the file as newline delimited JSON, masking secret headers")
+	pflag.StringVar(&traceSample, "trace-sample", "1%", "share of requests to write to --trace-log, as a percentage such as 1% or a fraction such as 0.01")
+	pflag.StringVar(&maxMemory, "max-memory", "", "heap size to keep the load generator under, such as 2GiB; over it, plot sampling stops and then the request rate is halved until it recovers")
+	pflag.StringVar(&notifyURL, "notify-url", "", "webhook to POST the summary to as JSON when the load test finishes, such as a Slack incoming webhook")
+	pflag.StringArrayVar(&sinks, "sink", nil, "push metrics while the load test runs to a sink, as KIND://TARGET, such as \"prom-remote-write://http://mimir:9009/api/v1/push\" (may be repeated)")
+	pflag.DurationVar(&sinkInterval, "sink-interval", 10*time.Second, "how often to push metrics to each --sink")
+	pflag.BoolVar(&soak, "soak", false, "monitor the load generator's own resource usage, and warn if it becomes the bottleneck")
+	pflag.DurationVar(&soakInterval, "soak-interval", 10*time.Second, "how often to sample the load generator's resource usage when --soak is set")
+	pflag.StringVar(&patternSpec, "pattern", "", "repeat a pattern of phases instead of a steady --requests-per-second, as NAME:RATE:LENGTH or NAME:LENGTH to send nothing, such as \"burst:500rps:10s,idle:50s\" or \"ramp:0-500rps:60s\", reporting each phase separately")
+	pflag.StringVar(&spikeSpec, "spike", "", "periodically multiply the request rate for a short time, such as \"10x:30s\"")
+	pflag.DurationVar(&spikeInterval, "spike-interval", 5*time.Minute, "how often to spike the request rate when --spike is set")
+	pflag.StringVar(&mix, "mix", "", "send a weighted mix of requests to paths under the URL, as METHOD:PATH=WEIGHT, such as \"GET:/items=70,POST:/items=25,DELETE:/items/{{id}}=5\", reporting each separately; {{NAME}} is a random integer in each request, or {{NAME:SPEC}} a value as for --query-random")
+	pflag.StringVar(&split, "split", "", "split the load between URLs by weight, such as \"https://old.example.com=50,https://new.example.com=50\", comparing the latency and error rate of each with the first")
+	pflag.StringVarP(&targetsFile, "targets-file", "f", "", "file to read targets from, or - to read from stdin")
 	pflag.IntVarP(&timeoutSeconds, "timeout-seconds", "t", 10, "maximum number of seconds for each request to complete before it timesout")
+	pflag.DurationVar(&totalTimeout, "total-timeout", 0, "maximum time for each request to complete, overriding --timeout-seconds, such as 2500ms")
+	pflag.DurationVar(&connectTimeout, "connect-timeout", 30*time.Second, "maximum time to establish each TCP connection")
+	pflag.DurationVar(&headerTimeout, "response-header-timeout", 0, "maximum time to wait for the response headers once the request is written, or 0 for no limit other than --total-timeout")
+	pflag.StringVar(&setupFile, "setup-file", "", "file containing a request, in the --targets-file format, to send once before the load test, such as a login, following any redirects with the cookies they set")
+	pflag.StringArrayVar(&extract, "extract", nil, "value to take from the setup response as NAME=SOURCE:EXPR, where SOURCE is json, header or regexp, to use as ${NAME} in URLs, headers and bodies (may be repeated)")
+	pflag.StringVar(&thinkTimeSpec, "think-time", "", "how long each worker pauses after a request, as \"fixed 500ms\" or \"uniform 100ms-800ms\"; the pause is not included in latencies")
 	pflag.StringToStringVarP(&headers, "headers", "e", map[string]string{}, "additional headers to include in each request")
-	pflag.IntSliceVarP(&okCodes, "ok-codes", "o", []int{200}, "list of status codes to consider as OK")
+	pflag.StringArrayVar(&headerRotate, "header-rotate", nil, "header to give the next value from a file in each request, as Name=@path with one value per line, such as \"X-Api-Key=@keys.txt\" (may be repeated)")
+	pflag.StringVar(&cacheBust, "cache-bust", "", "give each request a unique value to force cache misses, in the query parameter NAME or, as header:NAME, a header")
+	pflag.Lookup("cache-bust").NoOptDefVal = cacheBustDefault
+	pflag.BoolVar(&cacheOnly, "cache-only", false, "send identical requests, refusing options which vary them, to measure cache hits")
+	pflag.BoolVar(&revalidate, "revalidate", false, "make GET and HEAD requests conditional on the ETag and Last-Modified of the last response from the same URL, counting 304 Not Modified responses as OK and separately")
+	pflag.StringArrayVar(&queryRandom, "query-random", nil, "query parameter to give a random value in each request, as name=int:MIN-MAX, name=string:LENGTH or name=choice:a,b,c (may be repeated)")
+	pflag.StringVar(&jsonBody, "json", "", "JSON to POST as the body of each request, with a Content-Type of application/json")
+	pflag.StringArrayVar(&form, "form", nil, "form fields to POST in each request, as name=value&... or name=@path to upload a file, sent as multipart/form-data if there are any files (may be repeated)")
+	pflag.StringVar(&graphqlQuery, "graphql-query", "", "file containing a GraphQL query to POST to each URL, counting responses with errors as failures")
+	pflag.StringVar(&graphqlVars, "graphql-vars", "", "JSON file of variables for --graphql-query")
+	pflag.StringSliceVarP(&okCodes, "ok-codes", "o", []string{"200"}, "list of status codes to consider as OK, as codes such as 302, ranges such as 200-299 or classes such as 2xx")
+	pflag.StringVar(&readBody, "read-body", string(bodyFull), "how to read response bodies: full to include the download in the latency, discard to drain them afterwards so connections are re-used, or none to time the headers only")
+	pflag.IntSliceVar(&expectCodes, "expect-codes", nil, "list of status codes that are the expected outcome, such as 429 when testing a rate limiter; they count as OK, and the summary shows when the target started returning them")
+	pflag.DurationVar(&apdexT, "apdex-t", 0, "target time T for an Apdex score in the summary; ok requests within T are satisfied, and within --apdex-frustrated tolerating")
+	pflag.DurationVar(&apdexFrustrated, "apdex-frustrated", 0, "latency above which a request is frustrated when --apdex-t is set, as are failed requests (default 4 times --apdex-t)")
+	pflag.StringArrayVar(&expectHeaders, "expect-header", nil, "response header that must be present, as \"Name\" or \"Name: value\" where the header must contain value (may be repeated)")
+	pflag.BoolVar(&followRedirects, "follow-redirects", false, "follow redirects instead of counting the 3xx response by its status code")
+	pflag.IntVar(&maxIdleConnsPerHost, "max-idle-conns-per-host", 100, "maximum number of idle connections to keep open to each host for re-use")
+	pflag.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "maximum number of connections to each host, or 0 for no limit")
+	pflag.DurationVar(&idleConnTimeout, "idle-conn-timeout", 90*time.Second, "how long an idle connection is kept open for re-use")
+	pflag.BoolVar(&dnsCache, "dns-cache", true, "cache the addresses of target hosts for --dns-ttl; if false, hosts are resolved for every new connection")
+	pflag.DurationVar(&dnsTTL, "dns-ttl", 30*time.Second, "how long to cache the addresses of target hosts before resolving them again; new connections are spread across all of a host's addresses")
+	pflag.StringVar(&digestAuth, "digest-auth", "", "authenticate with HTTP Digest as user:pass, answering the first challenge from each host and re-using it until the host sends another")
+	pflag.BoolVar(&disableKeepAlive, "disable-keep-alive", false, "open a new connection for every request and close it afterwards, to measure the target's capacity for handshakes rather than requests")
+	pflag.BoolVar(&tcpNoDelay, "tcp-no-delay", true, "disable Nagle's algorithm, sending small writes without delay")
+	pflag.StringVar(&throttleSpec, "throttle", "", "limit each connection's reads and writes to a bandwidth in bits per second, such as 1mbps or 384kbps, to simulate slow clients")
+	pflag.StringVar(&localAddr, "local-addr", "", "local IP address to send requests from, such as that of a particular network interface")
+	pflag.StringVar(&localAddrRange, "local-addr-range", "", "range of local IP addresses to rotate connections across, such as \"10.0.0.10-10.0.0.50\"")
+	pflag.StringVar(&tlsMinVersion, "tls-min-version", "", "lowest TLS version to negotiate, one of 1.0, 1.1, 1.2 or 1.3")
+	pflag.StringVar(&tlsMaxVersion, "tls-max-version", "", "highest TLS version to negotiate, one of 1.0, 1.1, 1.2 or 1.3")
+	pflag.StringSliceVar(&tlsCiphers, "tls-ciphers", nil, "comma separated TLS 1.0-1.2 cipher suites to offer, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256; TLS 1.3 suites can't be chosen")
+	pflag.StringVar(&sni, "sni", "", "TLS server name to send and verify the certificate against, instead of the URL's host, such as when requesting an IP address")
+	pflag.IntVar(&maxRedirects, "max-redirects", 10, "maximum number of redirects to follow when --follow-redirects is set")
+	pflag.StringVar(&requestIDHeader, "request-id-header", "", "header to send a unique ID in with each request, such as X-Request-Id, so that requests can be found in the target's logs")
+	pflag.StringVar(&awsSigV4, "aws-sigv4", "", "sign each request with AWS Signature Version 4 for REGION/SERVICE, such as eu-west-1/execute-api, using credentials from the environment, ~/.aws/credentials, or the container or instance role")
+	pflag.StringVar(&userAgent, "user-agent", "slt/"+version, "User-Agent to send with each request")
+	pflag.IntVar(&maxQueue, "max-queue", 0, "most requests to queue while every worker is busy, beyond which requests are skipped and counted, or 0 for as many as are sent each second, up to 10000")
+	pflag.IntVarP(&workers, "workers", "w", 0, "number of workers sending requests, or 0 to add workers as needed to sustain the request rate")
+	pflag.BoolVar(&clientPerWorker, "client-per-worker", false, "give each worker its own client, with its own connection pool and cookie jar, to simulate independent clients rather than one with a shared pool")
+	pflag.StringVar(&userAgentFile, "user-agent-file", "", "file of User-Agents, one per line, to rotate through for each request")
 }
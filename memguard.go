@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memoryCheckInterval is how often the heap is checked against
+	// --max-memory
+	memoryCheckInterval = time.Second
+
+	// memoryCooldown is how many checks to wait after reducing the rate before
+	// reducing it again, giving the requests in flight time to finish
+	memoryCooldown = 5
+)
+
+// sizeUnits are the suffixes accepted by parseSize, longest first so that
+// "MiB" isn't read as "B"
+var sizeUnits = []struct {
+	suffix string
+	bytes  uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a number of bytes, such as "512MB" or "2GiB", where a
+// number without a unit is in bytes
+func parseSize(s string) (uint64, error) {
+	n, unit := s, uint64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			n, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.bytes
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size %q: expected a positive number of bytes, such as 512MB or 2GiB", s)
+	}
+	return uint64(v * float64(unit)), nil
+}
+
+// memoryGuard keeps the load generator's heap below a limit, so that a long
+// or fast load test degrades instead of running out of memory. Over the
+// limit, it first stops sampling requests for --plot and collects garbage,
+// then halves the request rate, or every rate of a --pattern, until the heap
+// is back under the limit.
+type memoryGuard struct {
+	lt       *loadTest
+	limit    uint64
+	shed     bool // true once plot sampling has been stopped
+	cooldown int
+	atMin    bool // true once the rate can't be reduced further
+}
+
+// run checks the heap every memoryCheckInterval until stop is closed
+func (g *memoryGuard) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check sheds memory if the heap is over the limit
+func (g *memoryGuard) check() {
+	logger := g.lt.logger
+	if heapAlloc() <= g.limit {
+		g.cooldown = 0
+		return
+	}
+	if g.cooldown > 0 {
+		g.cooldown--
+		return
+	}
+
+	if !g.shed {
+		g.shed = true
+		g.lt.series.stopSampling()
+		logger.Warnf("Memory is over --max-memory %s, so requests are no longer sampled for plotting", formatBytes(g.limit))
+	}
+	runtime.GC()
+	heap := heapAlloc()
+	if heap <= g.limit {
+		return
+	}
+
+	rps := int(atomic.LoadInt64(&g.lt.rps))
+	if rps <= 1 {
+		if !g.atMin {
+			g.atMin = true
+			logger.Warnf("Heap is %s, over --max-memory %s, but the rate can't be reduced any further", formatBytes(heap), formatBytes(g.limit))
+		}
+		return
+	}
+	logger.Warnf("Heap is %s, over --max-memory %s, so halving the rate", formatBytes(heap), formatBytes(g.limit))
+	g.lt.setRate(rps / 2)
+	g.cooldown = memoryCooldown
+}
+
+// heapAlloc returns the number of bytes allocated on the heap
+func heapAlloc() uint64 {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return mem.HeapAlloc
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// mixPlaceholderPattern matches a placeholder in the path of a --mix entry,
+// such as "{{id}}" or "{{id:int:1-500}}"
+var mixPlaceholderPattern = regexp.MustCompile(`\{\{([A-Za-z0-9_]+)(?::([^}]*))?\}\}`)
+
+// mixMethodPattern matches the method of a --mix entry
+var mixMethodPattern = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// defaultMixPlaceholder is the random value given to a placeholder without a
+// spec, as for --query-random
+const defaultMixPlaceholder = "int:1-100000"
+
+// parseMix parses a --mix of weighted requests to paths under the URL of
+// base, such as "GET:/items=70,POST:/items=25,DELETE:/items/{{id}}=5". Each
+// entry becomes a target tagged with its method and path, so its statistics
+// are reported separately.
+//
+// A path, or its query string, may contain placeholders which are given a new
+// random value in each request, as "{{NAME}}" for an integer from 1 to
+// 100000, or "{{NAME:SPEC}}" where SPEC is as for --query-random, such as
+// "{{sku:string:8}}".
+func parseMix(s string, base target) ([]target, error) {
+	var targets []target
+	for _, entry := range splitMix(s) {
+		i := strings.Index(entry, ":")
+		j := strings.LastIndex(entry, "=")
+		if i <= 0 || j < i {
+			return nil, fmt.Errorf("invalid --mix entry %q: expected METHOD:PATH=WEIGHT, such as \"GET:/items=70\"", entry)
+		}
+		method, path := strings.ToUpper(entry[:i]), entry[i+1:j]
+		if !mixMethodPattern.MatchString(method) {
+			return nil, fmt.Errorf("invalid --mix entry %q: %q is not a method", entry, entry[:i])
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid --mix entry %q: the path must start with /", entry)
+		}
+		weight, err := parseWeight(entry[j+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --mix entry %q: %w", entry, err)
+		}
+
+		// Placeholders are reduced to their names, which are replaced in
+		// each request
+		var params []randomParam
+		for _, m := range mixPlaceholderPattern.FindAllStringSubmatch(path, -1) {
+			spec := m[2]
+			if spec == "" {
+				spec = defaultMixPlaceholder
+			}
+			p, err := parseRandomParam(m[1] + "=" + spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --mix entry %q: %w", entry, err)
+			}
+			params = append(params, p)
+		}
+		path = mixPlaceholderPattern.ReplaceAllString(path, "{{$1}}")
+
+		// The path is added to the base URL's, before any query string, which
+		// is joined to the path's own
+		u, query := base.url, ""
+		if k := strings.Index(u, "?"); k >= 0 {
+			u, query = u[:k], u[k:]
+			if strings.Contains(path, "?") {
+				query = "&" + query[1:]
+			}
+		}
+		t, err := parseTarget(strings.TrimSuffix(u, "/") + path + query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --mix entry %q: %w", entry, err)
+		}
+		t.tag, t.method, t.weight, t.params = method+" "+path, method, weight, params
+		t.headers = http.Header{}
+		for key, vals := range base.headers {
+			t.headers[key] = vals
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("invalid --mix: expected at least one METHOD:PATH=WEIGHT entry")
+	}
+	return targets, nil
+}
+
+// splitMix splits a --mix into its entries at the commas which aren't in a
+// placeholder, so that a placeholder may be a choice such as "{{c:choice:a,b}}"
+func splitMix(s string) []string {
+	var entries []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(s[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case s[i] == ',' && depth == 0:
+			entries = append(entries, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(entries, strings.TrimSpace(s[start:]))
+}
+
+// applyMixBodyFlags applies the body given by --json, --form or
+// --graphql-query to the entries of a mix whose methods send a body, such as
+// POST, leaving the others, such as GET, without one
+func applyMixBodyFlags(targets []target) ([]target, error) {
+	var sending []target
+	for _, t := range targets {
+		if mixMethodSendsBody(t.method) {
+			sending = append(sending, t)
+		}
+	}
+	sending, fields, err := applyBodyFlags(sending)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 {
+		return nil, errors.New("--mix can't be used with --form files, which would be streamed to every entry")
+	}
+
+	result := make([]target, len(targets))
+	for i, t := range targets {
+		if mixMethodSendsBody(t.method) {
+			t, sending = sending[0], sending[1:]
+		}
+		result[i] = t
+	}
+	return result, nil
+}
+
+// mixMethodSendsBody returns true if requests with method usually have a
+// body
+func mixMethodSendsBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
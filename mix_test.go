@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	base := target{url: "https://example.com/api?v=2", headers: http.Header{"X-Key": {"k"}}}
+	type entry struct {
+		tag    string
+		method string
+		url    string
+		weight int
+		params []randomParam
+	}
+	tests := []struct {
+		in      string
+		want    []entry
+		wantErr bool
+	}{
+		{
+			in: "GET:/items=70,post:/items=25",
+			want: []entry{
+				{tag: "GET /items", method: "GET", url: "https://example.com/api/items?v=2", weight: 70},
+				{tag: "POST /items", method: "POST", url: "https://example.com/api/items?v=2", weight: 25},
+			},
+		},
+		{
+			in: "DELETE:/items/{{id}}=5",
+			want: []entry{{
+				tag: "DELETE /items/{{id}}", method: "DELETE", url: "https://example.com/api/items/{{id}}?v=2", weight: 5,
+				params: []randomParam{{name: "id", kind: "int", min: 1, max: 100000}},
+			}},
+		},
+		{
+			in: "GET:/items/{{id:int:-5-5}}?c={{c:choice:a,b}}=1",
+			want: []entry{{
+				tag: "GET /items/{{id}}?c={{c}}", method: "GET", url: "https://example.com/api/items/{{id}}?c={{c}}&v=2", weight: 1,
+				params: []randomParam{{name: "id", kind: "int", min: -5, max: 5}, {name: "c", kind: "choice", choices: []string{"a", "b"}}},
+			}},
+		},
+		{in: "", wantErr: true},
+		{in: "GET /items=1", wantErr: true},
+		{in: "GET:items=1", wantErr: true},
+		{in: "G3T:/items=1", wantErr: true},
+		{in: "GET:/items=0", wantErr: true},
+		{in: "GET:/items", wantErr: true},
+		{in: "GET:/items/{{id:int:5-1}}=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			targets, err := parseMix(tt.in, base)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", targets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []entry
+			for _, tg := range targets {
+				got = append(got, entry{tag: tg.tag, method: tg.method, url: tg.url, weight: tg.weight, params: tg.params})
+				if !reflect.DeepEqual(tg.headers, base.headers) {
+					t.Errorf("%s: got headers %v, want %v", tg.tag, tg.headers, base.headers)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "GET:/a=1", want: []string{"GET:/a=1"}},
+		{in: "GET:/a=1, POST:/b=2", want: []string{"GET:/a=1", "POST:/b=2"}},
+		{in: "GET:/{{c:choice:a,b}}=1,GET:/b=2", want: []string{"GET:/{{c:choice:a,b}}=1", "GET:/b=2"}},
+	}
+	for _, tt := range tests {
+		if got := splitMix(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitMix(%q): got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// checkInterval is how often named checks are evaluated, against the
+// requests which completed since the last evaluation
+const checkInterval = time.Second
+
+// namedCheck is a threshold from the config file's "checks" setting. Its
+// condition must hold for the requests to its tags and segments, or for all
+// requests if it has none.
+type namedCheck struct {
+	condition
+	name     string
+	tags     []string
+	segments []string
+}
+
+// parseConfigChecks parses the "checks" setting, which is a list of checks
+// each with a name, a condition and optionally the tags and segments, such as
+// spikes or the phases of a --pattern, that it is scoped to:
+//
+//	checks:
+//	  - name: checkout_p99
+//	    condition: p99<500ms
+//	    tags: [checkout]
+//	  - name: login_error_rate
+//	    condition: error_rate<1%
+//	    tags: [login]
+//	    segments: [burst]
+func parseConfigChecks(value interface{}) ([]namedCheck, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid checks: expected a list, not %v", value)
+	}
+
+	var checks []namedCheck
+	names := map[string]bool{}
+	for i, item := range items {
+		m, ok := configMap(item)
+		if !ok {
+			return nil, fmt.Errorf("invalid check %d: expected a map with a name and condition", i+1)
+		}
+		c, err := parseConfigCheck(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid check %d: %w", i+1, err)
+		}
+		if names[c.name] {
+			return nil, fmt.Errorf("invalid check %d: the name %q is already used", i+1, c.name)
+		}
+		names[c.name] = true
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// parseConfigCheck parses a check given as a map in the config file
+func parseConfigCheck(m map[string]interface{}) (namedCheck, error) {
+	for key := range m {
+		switch key {
+		case "name", "condition", "tags", "segments":
+		default:
+			return namedCheck{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	if m["name"] == nil || m["condition"] == nil {
+		return namedCheck{}, errors.New("name and condition are required")
+	}
+	cond, err := parseCondition(fmt.Sprint(m["condition"]))
+	if err != nil {
+		return namedCheck{}, err
+	}
+	c := namedCheck{condition: cond, name: fmt.Sprint(m["name"])}
+	if c.tags, err = configStrings(m["tags"]); err != nil {
+		return namedCheck{}, fmt.Errorf("tags %w", err)
+	}
+	if c.segments, err = configStrings(m["segments"]); err != nil {
+		return namedCheck{}, fmt.Errorf("segments %w", err)
+	}
+	return c, nil
+}
+
+// configStrings returns a setting which is a string or a list of strings as
+// a list, or nil if it isn't set
+func configStrings(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []interface{}:
+		var s []string
+		for _, v := range value {
+			s = append(s, fmt.Sprint(v))
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("must be a list, not %v", value)
+	}
+}
+
+// checkNamedChecks returns an error if a check in cfg is scoped to a tag or
+// segment which no request will have
+func checkNamedChecks(cfg config) error {
+	tags := map[string]bool{}
+	for _, t := range cfg.targets {
+		tags[t.tag] = true
+	}
+	segments := map[string]bool{}
+	if cfg.spike.interval > 0 {
+		segments[segmentBaseline], segments[segmentSpike] = true, true
+	}
+	for _, s := range cfg.pattern.segments() {
+		segments[s] = true
+	}
+
+	for _, c := range cfg.checks {
+		for _, tag := range c.tags {
+			if !tags[tag] {
+				return fmt.Errorf("check %s: no target has the tag %q", c.name, tag)
+			}
+		}
+		for _, s := range c.segments {
+			if !segments[s] {
+				return fmt.Errorf("check %s: there is no segment %q, only spikes and the phases of a --pattern are segments", c.name, s)
+			}
+		}
+	}
+	return nil
+}
+
+// covers returns true if the check applies to the request with result r
+func (c namedCheck) covers(r result) bool {
+	return (len(c.tags) == 0 || containsString(c.tags, r.tag)) && (len(c.segments) == 0 || containsString(c.segments, r.segment))
+}
+
+// scope returns a description of the requests the check applies to
+func (c namedCheck) scope() string {
+	var scope []string
+	if len(c.tags) > 0 {
+		scope = append(scope, "tags "+strings.Join(c.tags, ", "))
+	}
+	if len(c.segments) > 0 {
+		scope = append(scope, "segments "+strings.Join(c.segments, ", "))
+	}
+	if len(scope) == 0 {
+		return "all requests"
+	}
+	return strings.Join(scope, "; ")
+}
+
+// containsString returns true if s is one of list
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkState is the state of a named check in one interval
+type checkState uint8
+
+const (
+	checkIdle    checkState = iota // no requests it covers completed
+	checkPassing                   // its condition held
+	checkFailing                   // its condition didn't hold
+)
+
+// checkMonitor evaluates the named checks of a load test every
+// checkInterval, logging when each starts and stops failing. Each check's
+// requests are recorded in lock-free stats, and each interval is the
+// difference from a snapshot of them taken at the last evaluation, so that
+// observing requests never locks. It is safe for concurrent use.
+type checkMonitor struct {
+	checks []namedCheck
+	logger *xlog.Logger
+	totals []stats // of the requests each check covers
+
+	mu        sync.Mutex
+	last      []statsSnapshot // of each check's totals, at the last evaluation
+	results   []checkResults  // of each check's evaluations so far
+	states    [][]checkState  // of each check, in a ring of the latest intervals
+	intervals int             // number of intervals evaluated so far
+}
+
+// checkResults summarises the evaluations of a check
+type checkResults struct {
+	evaluated int        // intervals in which any requests it covers completed
+	failing   int        // of those, intervals in which it failed
+	last      checkState // in the last of those, or checkIdle if there were none
+}
+
+// newCheckMonitor returns a monitor for checks
+func newCheckMonitor(logger *xlog.Logger, checks []namedCheck) *checkMonitor {
+	return &checkMonitor{
+		checks:  checks,
+		logger:  logger,
+		totals:  make([]stats, len(checks)),
+		last:    make([]statsSnapshot, len(checks)),
+		results: make([]checkResults, len(checks)),
+		states:  make([][]checkState, len(checks)),
+	}
+}
+
+// observe adds the result of a request to the checks which cover it
+func (m *checkMonitor) observe(r result) {
+	for i, c := range m.checks {
+		if c.covers(r) {
+			m.totals[i].record(r)
+		}
+	}
+}
+
+// run evaluates the checks every checkInterval until stop is closed, then
+// evaluates them a final time against the requests since the last
+// evaluation
+func (m *checkMonitor) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.evaluate()
+		case <-stop:
+			m.evaluate()
+			return
+		}
+	}
+}
+
+// evaluate evaluates each check against the requests since the last
+// evaluation, logging any which start or stop failing. Intervals in which no
+// requests a check covers completed neither pass nor fail it.
+func (m *checkMonitor) evaluate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, c := range m.checks {
+		now := m.totals[i].snapshot()
+		d := now.sub(m.last[i])
+		m.last[i] = now
+
+		state := checkIdle
+		if d.counts.sent() > 0 {
+			v := c.value(d.counts, d.latencies)
+			state = checkPassing
+			if !c.holds(v) {
+				state = checkFailing
+			}
+			res := &m.results[i]
+			switch {
+			case state == checkFailing && res.last != checkFailing:
+				m.logger.Warnf("Check %s is failing: %s was %s, breaking %s", c.name, c.metric, c.format(v), c.text)
+			case state == checkPassing && res.last == checkFailing:
+				m.logger.Infof("Check %s is passing again: %s was %s", c.name, c.metric, c.format(v))
+			}
+			res.evaluated++
+			if state == checkFailing {
+				res.failing++
+			}
+			res.last = state
+		}
+
+		// The states are kept for as many seconds as the time series
+		if len(m.states[i]) < maxSeriesSeconds {
+			m.states[i] = append(m.states[i], state)
+		} else {
+			m.states[i][m.intervals%maxSeriesSeconds] = state
+		}
+	}
+	m.intervals++
+}
+
+// state returns the state of check i in the interval which ended at the end
+// of the given second of the load test, or checkIdle if it wasn't evaluated
+// or is no longer kept
+func (m *checkMonitor) state(i, second int) checkState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if second >= m.intervals || second < m.intervals-len(m.states[i]) {
+		return checkIdle
+	}
+	return m.states[i][second%maxSeriesSeconds]
+}
+
+// outcomes returns the outcome of each check over the whole load test. A
+// check which covers no requests fails.
+func (m *checkMonitor) outcomes() []outcome {
+	m.mu.Lock()
+	results := append([]checkResults(nil), m.results...)
+	m.mu.Unlock()
+
+	var outcomes []outcome
+	for i, c := range m.checks {
+		o := outcome{group: "checks", name: c.name}
+		cnt := m.totals[i].counts()
+		if cnt.sent() == 0 {
+			o.message = "no requests to " + c.scope() + " completed"
+			outcomes = append(outcomes, o)
+			continue
+		}
+
+		v := c.value(cnt, m.totals[i].latencyHistogram(phaseTotal))
+		o.passed = c.holds(v)
+		if !o.passed {
+			r := results[i]
+			o.message = fmt.Sprintf("%s was %s, breaking %s, and it failed in %d of the %d seconds evaluated", c.metric, c.format(v), c.text, r.failing, r.evaluated)
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes
+}
+
+// logChecks logs whether each named check passed over the whole load test
+func (m *checkMonitor) logChecks(logger *xlog.Logger) {
+	for i, o := range m.outcomes() {
+		c := m.checks[i]
+		if o.passed {
+			logger.Infof("Check %s (%s, for %s): passed", c.name, c.text, c.scope())
+		} else {
+			logger.Warnf("Check %s (%s, for %s): failed, %s", c.name, c.text, c.scope(), o.message)
+		}
+	}
+}
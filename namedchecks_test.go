@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigChecks(t *testing.T) {
+	p99, err := parseCondition("p99<500ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorRate, err := parseCondition("error_rate<1%")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		in      interface{}
+		want    []namedCheck
+		wantErr bool
+	}{
+		{
+			name: "unscoped",
+			in:   []interface{}{map[interface{}]interface{}{"name": "p99", "condition": "p99<500ms"}},
+			want: []namedCheck{{condition: p99, name: "p99"}},
+		},
+		{
+			name: "scoped",
+			in: []interface{}{
+				map[interface{}]interface{}{"name": "checkout_p99", "condition": "p99<500ms", "tags": []interface{}{"checkout", "cart"}},
+				map[string]interface{}{"name": "login_error_rate", "condition": "error_rate<1%", "tags": "login", "segments": []interface{}{"burst"}},
+			},
+			want: []namedCheck{
+				{condition: p99, name: "checkout_p99", tags: []string{"checkout", "cart"}},
+				{condition: errorRate, name: "login_error_rate", tags: []string{"login"}, segments: []string{"burst"}},
+			},
+		},
+		{name: "not a list", in: "p99<500ms", wantErr: true},
+		{name: "not a map", in: []interface{}{"p99<500ms"}, wantErr: true},
+		{name: "no name", in: []interface{}{map[string]interface{}{"condition": "p99<500ms"}}, wantErr: true},
+		{name: "no condition", in: []interface{}{map[string]interface{}{"name": "a"}}, wantErr: true},
+		{name: "bad condition", in: []interface{}{map[string]interface{}{"name": "a", "condition": "p42<1s"}}, wantErr: true},
+		{name: "unknown setting", in: []interface{}{map[string]interface{}{"name": "a", "condition": "p99<1s", "tag": "b"}}, wantErr: true},
+		{name: "tags not a list", in: []interface{}{map[string]interface{}{"name": "a", "condition": "p99<1s", "tags": 3}}, wantErr: true},
+		{
+			name: "duplicate name",
+			in: []interface{}{
+				map[string]interface{}{"name": "a", "condition": "p99<1s"},
+				map[string]interface{}{"name": "a", "condition": "p50<1s"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfigChecks(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
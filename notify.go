@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notifyTimeout is how long to wait for the --notify-url webhook to respond
+const notifyTimeout = 10 * time.Second
+
+// notification is the JSON body posted to the --notify-url webhook. The text
+// field means it can be posted directly to a Slack incoming webhook.
+type notification struct {
+	Text      string              `json:"text"`
+	Status    string              `json:"status"`
+	Reason    string              `json:"reason,omitempty"`
+	Version   string              `json:"version"`
+	Targets   []string            `json:"targets"`
+	Start     time.Time           `json:"start"`
+	Duration  float64             `json:"duration_seconds"` // not counting time paused
+	Paused    float64             `json:"paused_seconds,omitempty"`
+	Requests  int                 `json:"requests"`
+	OK        int                 `json:"ok"`
+	Failures  int                 `json:"failures"`
+	Latencies map[string]float64  `json:"latency_ms,omitempty"`
+	Checks    []notificationCheck `json:"checks"`
+}
+
+type notificationCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// notify posts the summary of lt, which finished with err, to the
+// --notify-url webhook
+func (lt *loadTest) notify(err error) error {
+	n := lt.notification(err)
+	body, jsonErr := json.Marshal(n)
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	// The load test's context may already be cancelled, if it was
+	// interrupted, but the notification should still be sent
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, lt.cfg.notifyURL, bytes.NewReader(body))
+	if reqErr != nil {
+		return reqErr
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", lt.cfg.userAgent)
+
+	resp, doErr := http.DefaultClient.Do(req)
+	if doErr != nil {
+		return doErr
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %q", resp.Status)
+	}
+	return nil
+}
+
+// notification returns the notification for lt, which finished with err
+func (lt *loadTest) notification(err error) notification {
+	c := lt.stats.all.counts()
+	n := notification{
+		Status:    "passed",
+		Version:   version,
+		Start:     lt.start,
+		Duration:  lt.activeFor().Seconds(),
+		Paused:    lt.pausedFor().Seconds(),
+		Requests:  c.sent(),
+		OK:        c.ok,
+		Failures:  c.failed,
+		Latencies: latencyMillis(lt.stats.all.latencyHistogram(phaseTotal)),
+	}
+	for _, t := range lt.targets {
+		n.Targets = append(n.Targets, t.url)
+	}
+
+	for _, o := range lt.outcomes(err) {
+		n.Checks = append(n.Checks, newNotificationCheck(o))
+		if !o.passed && n.Status == "passed" {
+			n.Status = "failed"
+		}
+	}
+	switch e := err.(type) {
+	case nil:
+		if lt.interrupted {
+			n.Status = "interrupted"
+		}
+	case abortError:
+		n.Status = "aborted"
+		n.Reason = e.reason
+	default:
+		n.Status = "errored"
+		n.Reason = err.Error()
+	}
+
+	n.Text = lt.notificationText(n)
+	return n
+}
+
+// newNotificationCheck returns the outcome o of a check, as it is shown in
+// notifications
+func newNotificationCheck(o outcome) notificationCheck {
+	name := o.name
+	if o.group != "" {
+		name = o.group + ": " + name
+	}
+	return notificationCheck{Name: name, Passed: o.passed, Message: o.message}
+}
+
+// notificationText returns a human readable summary of n
+func (lt *loadTest) notificationText(n notification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Load test of %s %s after %v", strings.Join(n.Targets, ", "), n.Status, time.Duration(n.Duration*float64(time.Second)).Round(time.Second))
+	if n.Reason != "" {
+		fmt.Fprintf(&b, ": %s", n.Reason)
+	}
+	fmt.Fprintf(&b, "\nSent %d requests, %d ok, %d failures", n.Requests, n.OK, n.Failures)
+	if n.Latencies != nil {
+		fmt.Fprintf(&b, "\nLatency p50 %.1fms, p90 %.1fms, p99 %.1fms, max %.1fms", n.Latencies["p50"], n.Latencies["p90"], n.Latencies["p99"], n.Latencies["max"])
+	}
+	for _, c := range n.Checks {
+		if !c.Passed {
+			fmt.Fprintf(&b, "\nFailed %s: %s", c.Name, c.Message)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// patternPhase is one phase of a load pattern, during which the rate ramps
+// linearly from one value to another, or holds steady if they are equal
+type patternPhase struct {
+	name   string // the segment its requests are reported in
+	from   int
+	to     int
+	length time.Duration
+}
+
+// pattern is a sequence of phases which repeats for as long as the load
+// test runs, such as a square wave of bursts and idle periods
+type pattern []patternPhase
+
+// parsePattern parses a comma separated list of phases of the form
+// "NAME:RATE:LENGTH", where RATE is a request rate such as "500rps" or a ramp
+// such as "0-500rps", or "NAME:LENGTH" for a phase which sends no requests.
+// For example, "burst:500rps:10s,idle:50s" is a square wave, and
+// "ramp:0-500rps:60s" a sawtooth.
+func parsePattern(s string) (pattern, error) {
+	var p pattern
+	for _, spec := range strings.Split(s, ",") {
+		phase, err := parsePatternPhase(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --pattern phase %q: %w", spec, err)
+		}
+		p = append(p, phase)
+	}
+	return p, nil
+}
+
+// parsePatternPhase parses a single phase of a pattern
+func parsePatternPhase(s string) (patternPhase, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return patternPhase{}, fmt.Errorf("expected NAME:RATE:LENGTH, such as \"burst:500rps:10s\", or NAME:LENGTH to send nothing")
+	}
+	phase := patternPhase{name: parts[0]}
+
+	if len(parts) == 3 {
+		rates := strings.SplitN(strings.TrimSuffix(parts[1], "rps"), "-", 2)
+		var err1, err2 error
+		phase.from, err1 = strconv.Atoi(rates[0])
+		phase.to, err2 = phase.from, nil
+		if len(rates) == 2 {
+			phase.to, err2 = strconv.Atoi(rates[1])
+		}
+		if err1 != nil || err2 != nil || phase.from < 0 || phase.to < 0 {
+			return patternPhase{}, fmt.Errorf("rate %q must be a number of requests per second, such as 500rps, or a range such as 0-500rps", parts[1])
+		}
+	}
+
+	length, err := time.ParseDuration(parts[len(parts)-1])
+	if err != nil || length < time.Second {
+		return patternPhase{}, fmt.Errorf("length %q must be a duration of at least 1s", parts[len(parts)-1])
+	}
+	phase.length = length
+	return phase, nil
+}
+
+// period returns how long the pattern takes before it repeats
+func (p pattern) period() time.Duration {
+	var d time.Duration
+	for _, phase := range p {
+		d += phase.length
+	}
+	return d
+}
+
+// at returns the request rate at elapsed after the load test started, and
+// the name of the phase it is in
+func (p pattern) at(elapsed time.Duration) (int, string) {
+	offset := elapsed % p.period()
+	for _, phase := range p {
+		if offset < phase.length {
+			progress := float64(offset) / float64(phase.length)
+			return phase.from + int(progress*float64(phase.to-phase.from)), phase.name
+		}
+		offset -= phase.length
+	}
+	return 0, "" // unreachable, as the offset is less than the period
+}
+
+// peak returns the highest request rate of any phase
+func (p pattern) peak() int {
+	var peak int
+	for _, phase := range p {
+		if phase.from > peak {
+			peak = phase.from
+		}
+		if phase.to > peak {
+			peak = phase.to
+		}
+	}
+	return peak
+}
+
+// segments returns the names of the phases which send requests, in the order
+// they first occur, to report the load test in
+func (p pattern) segments() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, phase := range p {
+		if (phase.from > 0 || phase.to > 0) && !seen[phase.name] {
+			names = append(names, phase.name)
+			seen[phase.name] = true
+		}
+	}
+	return names
+}
+
+// String returns the pattern in the form it is parsed from
+func (p pattern) String() string {
+	var phases []string
+	for _, phase := range p {
+		switch {
+		case phase.from == 0 && phase.to == 0:
+			phases = append(phases, fmt.Sprintf("%s:%v", phase.name, phase.length))
+		case phase.from == phase.to:
+			phases = append(phases, fmt.Sprintf("%s:%drps:%v", phase.name, phase.from, phase.length))
+		default:
+			phases = append(phases, fmt.Sprintf("%s:%d-%drps:%v", phase.name, phase.from, phase.to, phase.length))
+		}
+	}
+	return strings.Join(phases, ",")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// watchPauseSignals pauses the load test when pauseSignal is received and
+// resumes it on resumeSignal, until the load test stops. It does nothing on
+// platforms without the signals.
+func (lt *loadTest) watchPauseSignals() {
+	if pauseSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, pauseSignal, resumeSignal)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case s := <-signals:
+				lt.setPaused(s == pauseSignal)
+			case <-lt.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stopped records that the load test has stopped sending requests, so that
+// the time spent draining those in flight doesn't count as active
+func (lt *loadTest) stopped() {
+	lt.pauseMu.Lock()
+	defer lt.pauseMu.Unlock()
+	lt.stoppedAt = time.Now()
+}
+
+// now returns the current time, or the time the load test stopped sending
+// requests once it has
+func (lt *loadTest) now() time.Time {
+	lt.pauseMu.Lock()
+	defer lt.pauseMu.Unlock()
+	if !lt.stoppedAt.IsZero() {
+		return lt.stoppedAt
+	}
+	return time.Now()
+}
+
+// pausedFor returns the total time the load test has spent paused so far
+func (lt *loadTest) pausedFor() time.Duration {
+	now := lt.now()
+	lt.pauseMu.Lock()
+	defer lt.pauseMu.Unlock()
+	d := lt.pausedTotal
+	if !lt.pausedAt.IsZero() {
+		d += now.Sub(lt.pausedAt)
+	}
+	return d
+}
+
+// activeFor returns how long the load test has been sending requests, not
+// counting any time spent paused
+func (lt *loadTest) activeFor() time.Duration {
+	return lt.now().Sub(lt.start) - lt.pausedFor()
+}
+
+// stopAfter calls cancel once the load test has been active for d, so that
+// time spent paused doesn't count towards the duration
+func (lt *loadTest) stopAfter(d time.Duration, cancel context.CancelFunc) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-lt.ctx.Done():
+			return
+		}
+		remaining := d - lt.activeFor()
+		if remaining <= 0 {
+			cancel()
+			return
+		}
+		timer.Reset(remaining)
+	}
+}
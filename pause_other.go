@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// pauseSignal and resumeSignal are nil, as this platform has no signals to
+// pause and resume a running load test with
+var pauseSignal, resumeSignal os.Signal
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// maxPlotPoints is the most requests drawn in a plot. Beyond this the
+	// requests are sampled, so that the file stays small enough for a browser,
+	// with half the samples kept for slow and failed requests so that they
+	// aren't lost among the rest.
+	maxPlotPoints = 20000
+
+	plotWidth, plotHeight = 1000, 500
+	plotMarginLeft        = 70
+	plotMarginRight       = 20
+	plotMarginTop         = 20
+	plotMarginBottom      = 50
+)
+
+// writePlot writes an HTML page to w with a scatter plot of the latency of
+// each request in lt against the time it was sent, so that periods of high
+// latency or failures stand out
+func writePlot(w io.Writer, lt *loadTest, err error) error {
+	points, total := lt.series.latencies()
+
+	var targets []string
+	for _, t := range lt.targets {
+		targets = append(targets, t.url)
+	}
+	title := "Latency of " + strings.Join(targets, ", ")
+
+	var maxStart, maxLatency time.Duration
+	for _, p := range points {
+		if p.start > maxStart {
+			maxStart = p.start
+		}
+		if p.latency > maxLatency {
+			maxLatency = p.latency
+		}
+	}
+	xMax := niceCeil(math.Max(maxStart.Seconds(), 1))
+	yMax := niceCeil(math.Max(float64(maxLatency)/float64(time.Millisecond), 1))
+
+	innerWidth := float64(plotWidth - plotMarginLeft - plotMarginRight)
+	innerHeight := float64(plotHeight - plotMarginTop - plotMarginBottom)
+	x := func(secs float64) float64 { return plotMarginLeft + secs/xMax*innerWidth }
+	y := func(ms float64) float64 { return plotMarginTop + innerHeight - ms/yMax*innerHeight }
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", html.EscapeString(title))
+	fmt.Fprintf(bw, "<style>body { font-family: sans-serif; margin: 2em; } svg text { font-size: 12px; } .ok { fill: #1f77b4; } .failed { fill: #d62728; }</style>\n</head>\n<body>\n")
+	fmt.Fprintf(bw, "<h1>%s</h1>\n", html.EscapeString(title))
+	fmt.Fprintf(bw, "<p>%s</p>\n", html.EscapeString(plotSummary(lt, len(points), total)))
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n", plotWidth, plotHeight)
+
+	// Axes, with gridlines at each tick
+	for _, v := range ticks(xMax) {
+		fmt.Fprintf(bw, "<line x1=\"%.1f\" y1=\"%d\" x2=\"%.1f\" y2=\"%d\" stroke=\"#eee\"/>", x(v), plotMarginTop, x(v), plotHeight-plotMarginBottom)
+		fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%g</text>\n", x(v), plotHeight-plotMarginBottom+16, v)
+	}
+	for _, v := range ticks(yMax) {
+		fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\" stroke=\"#eee\"/>", plotMarginLeft, y(v), plotWidth-plotMarginRight, y(v))
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\" dominant-baseline=\"middle\">%g</text>\n", plotMarginLeft-6, y(v), v)
+	}
+	fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"black\"/>", plotMarginLeft, plotHeight-plotMarginBottom, plotWidth-plotMarginRight, plotHeight-plotMarginBottom)
+	fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"black\"/>\n", plotMarginLeft, plotMarginTop, plotMarginLeft, plotHeight-plotMarginBottom)
+	fmt.Fprintf(bw, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">Time since start (s)</text>\n", plotMarginLeft+innerWidth/2, plotHeight-10)
+	fmt.Fprintf(bw, "<text transform=\"translate(16 %.1f) rotate(-90)\" text-anchor=\"middle\">Latency (ms)</text>\n", plotMarginTop+innerHeight/2)
+
+	// Failures are drawn last so that they aren't hidden by successes
+	for _, ok := range []bool{true, false} {
+		class := "ok"
+		if !ok {
+			class = "failed"
+		}
+		fmt.Fprintf(bw, "<g class=\"%s\">\n", class)
+		for _, p := range points {
+			if p.ok == ok {
+				fmt.Fprintf(bw, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"1.5\"/>\n", x(p.start.Seconds()), y(float64(p.latency)/float64(time.Millisecond)))
+			}
+		}
+		fmt.Fprintf(bw, "</g>\n")
+	}
+
+	fmt.Fprintf(bw, "<circle class=\"ok\" cx=\"%d\" cy=\"%d\" r=\"4\"/><text x=\"%d\" y=\"%d\" dominant-baseline=\"middle\">OK</text>\n", plotWidth-plotMarginRight-110, plotMarginTop+10, plotWidth-plotMarginRight-100, plotMarginTop+10)
+	fmt.Fprintf(bw, "<circle class=\"failed\" cx=\"%d\" cy=\"%d\" r=\"4\"/><text x=\"%d\" y=\"%d\" dominant-baseline=\"middle\">Failed</text>\n", plotWidth-plotMarginRight-60, plotMarginTop+10, plotWidth-plotMarginRight-50, plotMarginTop+10)
+	fmt.Fprintf(bw, "</svg>\n")
+	if lt.checker != nil {
+		fmt.Fprintf(bw, "<h2>Checks</h2>\n<ul>\n")
+		for i, o := range lt.checker.outcomes() {
+			c := lt.checker.checks[i]
+			result := "passed"
+			if !o.passed {
+				result = "failed, " + o.message
+			}
+			fmt.Fprintf(bw, "<li><b>%s</b> (%s, for %s): %s</li>\n", html.EscapeString(c.name), html.EscapeString(c.text), html.EscapeString(c.scope()), html.EscapeString(result))
+		}
+		fmt.Fprintf(bw, "</ul>\n")
+	}
+	fmt.Fprintf(bw, "</body>\n</html>\n")
+	return bw.Flush()
+}
+
+// plotSummary returns a line describing the requests in the plot of lt, which
+// shows n of the total sampled
+func plotSummary(lt *loadTest, n, total int) string {
+	c := lt.stats.all.counts()
+	s := fmt.Sprintf("%d requests, %d ok, %d failures, starting %s", c.sent(), c.ok, c.failed, lt.start.Format(time.RFC3339))
+	if n < total {
+		s += fmt.Sprintf(" (%d plotted; slow or failed requests are sampled separately from the rest, so that they stand out)", n)
+	} else if n == 0 && c.sent() > 0 {
+		s += " (no requests plotted, as sampling was stopped to save memory)"
+	}
+	return s
+}
+
+// niceCeil rounds v up to 1, 2 or 5 times a power of 10, so that axes end on
+// a round number
+func niceCeil(v float64) float64 {
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// ticks returns the values at which to mark an axis running from 0 to max,
+// which is a round number as returned by niceCeil
+func ticks(max float64) []float64 {
+	step := max / 5
+	if first := max / math.Pow(10, math.Floor(math.Log10(max))); first == 2 {
+		step = max / 4
+	}
+	var values []float64
+	for i := 0; i <= 5; i++ {
+		v := float64(i) * step
+		if v > max*1.0001 {
+			break
+		}
+		values = append(values, math.Round(v*1e6)/1e6)
+	}
+	return values
+}
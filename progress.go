@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressBarWidth   = 30
+	progressBarRefresh = 250 * time.Millisecond
+)
+
+// progressBar shows the progress of a bounded load test on a single line,
+// which is redrawn as the test progresses
+type progressBar struct {
+	lt      *loadTest
+	out     io.Writer
+	stopped chan struct{}
+	done    chan struct{}
+
+	// samples of the number of requests sent, used to calculate the rate
+	// over the last second
+	samples []rateSample
+}
+
+// rateSample is the number of requests sent at a point in time
+type rateSample struct {
+	t    time.Time
+	sent int
+}
+
+// newProgressBar returns a progress bar for lt which is drawn to out
+func newProgressBar(lt *loadTest, out io.Writer) *progressBar {
+	return &progressBar{
+		lt:      lt,
+		out:     out,
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// run redraws the progress bar until it is stopped
+func (p *progressBar) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(progressBarRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.draw()
+		case <-p.stopped:
+			p.draw()
+			fmt.Fprintln(p.out)
+			return
+		}
+	}
+}
+
+// stop draws the progress bar one last time and moves on to a new line, so
+// that any further output is not drawn over it
+func (p *progressBar) stop() {
+	close(p.stopped)
+	<-p.done
+}
+
+// draw redraws the progress bar
+func (p *progressBar) draw() {
+	now := time.Now()
+	c := p.lt.stats.all.counts()
+	elapsed := p.lt.activeFor()
+	cfg := p.lt.cfg
+
+	// Keep just enough samples to measure the rate over the last second
+	p.samples = append(p.samples, rateSample{t: now, sent: c.sent()})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].t) >= time.Second {
+		p.samples = p.samples[1:]
+	}
+	var rate float64
+	if oldest := p.samples[0]; now.After(oldest.t) {
+		rate = float64(c.sent()-oldest.sent) / now.Sub(oldest.t).Seconds()
+	}
+
+	// The test finishes at whichever limit is reached first
+	var progress float64
+	eta := time.Duration(-1)
+	if cfg.duration > 0 {
+		progress = elapsed.Seconds() / cfg.duration.Seconds()
+		eta = cfg.duration - elapsed
+	}
+	if cfg.requests > 0 {
+		progress = maxFloat(progress, float64(c.sent())/float64(cfg.requests))
+		if rate > 0 {
+			remaining := time.Duration(float64(cfg.requests-c.sent()) / rate * float64(time.Second))
+			if eta < 0 || remaining < eta {
+				eta = remaining
+			}
+		}
+	}
+	if progress > 1 {
+		progress = 1
+	}
+	if eta < 0 {
+		eta = 0
+	}
+
+	filled := int(progress * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(p.out, "\r[%s] %3.0f%% %v elapsed, ETA %v, %.1f req/s, %d sent, %d failures\x1b[K",
+		bar, progress*100, elapsed.Round(time.Second), eta.Round(time.Second), rate, c.sent(), c.failed)
+}
+
+// maxFloat returns the larger of a and b
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// isTerminal returns true if f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// promRemoteWriteTimeout bounds each push to a remote write receiver
+const promRemoteWriteTimeout = 10 * time.Second
+
+// promQuantiles are the latency quantiles pushed for each interval
+var promQuantiles = []float64{0.5, 0.9, 0.99}
+
+// promRemoteWrite pushes metrics to a Prometheus remote write receiver, such
+// as Prometheus, Mimir or Thanos, for when an ephemeral load test can't be
+// scraped. Counters are cumulative, and latency quantiles are over the time
+// since the last push.
+type promRemoteWrite struct {
+	url    string
+	client http.Client
+	last   map[string]*histogram // total latencies at the last push, by tag
+}
+
+// promSeries is a single sample of a time series
+type promSeries struct {
+	labels map[string]string // including __name__
+	value  float64
+}
+
+// newPromRemoteWrite returns a sink which pushes to the receiver at target,
+// which must be an http or https URL
+func newPromRemoteWrite(target string) (sink, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", target)
+	}
+	return &promRemoteWrite{url: target, client: http.Client{Timeout: promRemoteWriteTimeout}, last: map[string]*histogram{}}, nil
+}
+
+func (p *promRemoteWrite) push(lt *loadTest, now time.Time) error {
+	var series []promSeries
+	add := func(name string, value float64, labels ...string) {
+		s := promSeries{labels: map[string]string{"__name__": name, "job": "slt"}, value: value}
+		for i := 0; i+1 < len(labels); i += 2 {
+			s.labels[labels[i]] = labels[i+1]
+		}
+		series = append(series, s)
+	}
+
+	add("slt_target_requests_per_second", float64(atomic.LoadInt64(&lt.rps)))
+	for _, tag := range lt.stats.tags {
+		s := lt.stats.byTag[tag]
+		c := s.counts()
+		add("slt_requests_total", float64(c.ok), "tag", tag, "result", "ok")
+		add("slt_requests_total", float64(c.failed), "tag", tag, "result", "failed")
+		add("slt_received_bytes_total", float64(c.bytes), "tag", tag)
+
+		latencies := s.latencyHistogram(phaseTotal)
+		interval := latencies
+		if last, ok := p.last[tag]; ok {
+			interval = latencies.sub(last)
+		}
+		p.last[tag] = latencies
+		if interval.count() == 0 {
+			continue
+		}
+		for _, q := range promQuantiles {
+			add("slt_latency_seconds", interval.percentile(q*100).Seconds(), "tag", tag, "quantile", strconv.FormatFloat(q, 'g', -1, 64))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(snappyEncode(promWriteRequest(series, now))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "slt/"+version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("receiver returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// promWriteRequest returns the protobuf encoding of a remote write
+// WriteRequest holding series, each with a single sample at now
+func promWriteRequest(series []promSeries, now time.Time) []byte {
+	var req []byte
+	for _, s := range series {
+		// Labels must be sorted by name
+		var names []string
+		for name := range s.labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var ts []byte
+		for _, name := range names {
+			var label []byte
+			label = protoString(label, 1, name)
+			label = protoString(label, 2, s.labels[name])
+			ts = protoBytes(ts, 1, label)
+		}
+		var sample []byte
+		sample = protoKey(sample, 1, 1)
+		var value [8]byte
+		binary.LittleEndian.PutUint64(value[:], math.Float64bits(s.value))
+		sample = append(sample, value[:]...)
+		sample = protoKey(sample, 2, 0)
+		sample = appendUvarint(sample, uint64(now.UnixNano()/int64(time.Millisecond)))
+		ts = protoBytes(ts, 2, sample)
+
+		req = protoBytes(req, 1, ts)
+	}
+	return req
+}
+
+// protoKey appends the key of a protobuf field with the given number and wire
+// type
+func protoKey(b []byte, field, wireType int) []byte {
+	return appendUvarint(b, uint64(field<<3|wireType))
+}
+
+// appendUvarint appends v to b as a varint
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// protoBytes appends a length delimited protobuf field
+func protoBytes(b []byte, field int, v []byte) []byte {
+	b = protoKey(b, field, 2)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// protoString appends a protobuf string field
+func protoString(b []byte, field int, v string) []byte {
+	return protoBytes(b, field, []byte(v))
+}
+
+// snappyEncode returns b in the snappy block format, as a single literal.
+// This doesn't compress, but any snappy decoder can read it, and the pushes
+// are small.
+func snappyEncode(b []byte) []byte {
+	out := appendUvarint(nil, uint64(len(b)))
+	if len(b) == 0 {
+		return out
+	}
+
+	// The length of a literal is stored less one, in the tag if it is small
+	// or in the 1 to 4 bytes after it
+	n := uint32(len(b) - 1)
+	switch {
+	case n < 60:
+		out = append(out, byte(n)<<2)
+	case n < 1<<8:
+		out = append(out, 60<<2, byte(n))
+	case n < 1<<16:
+		out = append(out, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		out = append(out, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		out = append(out, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(out, b...)
+}
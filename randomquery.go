@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// randomAlphabet is the characters that random string parameters are made of
+const randomAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomParam is a query parameter given a random value in each request, so
+// that requests are spread across a key space rather than hitting one key
+type randomParam struct {
+	name    string
+	kind    string // one of int, string or choice
+	min     int    // the lowest int, or the length of a string
+	max     int    // the highest int
+	choices []string
+}
+
+// parseRandomParam parses a parameter of the form "name=int:MIN-MAX",
+// "name=string:LENGTH" or "name=choice:a,b,c"
+func parseRandomParam(s string) (randomParam, error) {
+	i := strings.Index(s, "=")
+	j := strings.Index(s, ":")
+	if i <= 0 || j < i {
+		return randomParam{}, fmt.Errorf("invalid random query parameter %q: expected name=KIND:SPEC, such as \"id=int:1-100000\"", s)
+	}
+	p := randomParam{name: s[:i], kind: s[i+1 : j]}
+	spec := s[j+1:]
+
+	switch p.kind {
+	case "int":
+		// The range is split after the sign of the first integer, if any, so
+		// that it may be negative, as in -5-5
+		k := -1
+		if spec != "" {
+			k = strings.Index(spec[1:], "-")
+		}
+		if k < 0 {
+			return randomParam{}, fmt.Errorf("invalid random query parameter %q: expected a range such as 1-100000", s)
+		}
+		var err1, err2 error
+		p.min, err1 = strconv.Atoi(spec[:k+1])
+		p.max, err2 = strconv.Atoi(spec[k+2:])
+		if err1 != nil || err2 != nil || p.max < p.min {
+			return randomParam{}, fmt.Errorf("invalid random query parameter %q: %q is not a range of integers", s, spec)
+		}
+	case "string":
+		n, err := strconv.Atoi(spec)
+		if err != nil || n <= 0 {
+			return randomParam{}, fmt.Errorf("invalid random query parameter %q: length must be a positive integer", s)
+		}
+		p.min = n
+	case "choice":
+		p.choices = strings.Split(spec, ",")
+	default:
+		return randomParam{}, fmt.Errorf("invalid random query parameter %q: kind must be int, string or choice", s)
+	}
+	return p, nil
+}
+
+// value returns a new random value for the parameter
+func (p randomParam) value() string {
+	switch p.kind {
+	case "int":
+		return strconv.Itoa(p.min + rand.Intn(p.max-p.min+1))
+	case "string":
+		b := make([]byte, p.min)
+		for i := range b {
+			b[i] = randomAlphabet[rand.Intn(len(randomAlphabet))]
+		}
+		return string(b)
+	default:
+		return p.choices[rand.Intn(len(p.choices))]
+	}
+}
+
+// addRandomParams sets each of params to a new random value in u, replacing
+// any existing value
+func addRandomParams(u *url.URL, params []randomParam) {
+	q := u.Query()
+	for _, p := range params {
+		q.Set(p.name, p.value())
+	}
+	u.RawQuery = q.Encode()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseRandomParam(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    randomParam
+		wantErr bool
+	}{
+		{in: "id=int:1-100000", want: randomParam{name: "id", kind: "int", min: 1, max: 100000}},
+		{in: "id=int:7-7", want: randomParam{name: "id", kind: "int", min: 7, max: 7}},
+		{in: "id=int:-5-5", want: randomParam{name: "id", kind: "int", min: -5, max: 5}},
+		{in: "id=int:-10--5", want: randomParam{name: "id", kind: "int", min: -10, max: -5}},
+		{in: "sku=string:8", want: randomParam{name: "sku", kind: "string", min: 8}},
+		{in: "c=choice:a,b,c", want: randomParam{name: "c", kind: "choice", choices: []string{"a", "b", "c"}}},
+		{in: "id=int:5-1", wantErr: true},
+		{in: "id=int:5", wantErr: true},
+		{in: "id=int:", wantErr: true},
+		{in: "id=int:-", wantErr: true},
+		{in: "id=int:a-b", wantErr: true},
+		{in: "sku=string:0", wantErr: true},
+		{in: "sku=string:x", wantErr: true},
+		{in: "id=float:1-2", wantErr: true},
+		{in: "=int:1-2", wantErr: true},
+		{in: "id:int=1-2", wantErr: true},
+		{in: "id", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRandomParam(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomParamValue(t *testing.T) {
+	p := randomParam{name: "id", kind: "int", min: -2, max: 2}
+	seen := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		v, err := strconv.Atoi(p.value())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v < p.min || v > p.max {
+			t.Fatalf("%d is outside %d-%d", v, p.min, p.max)
+		}
+		seen[v] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("got %d distinct values, want 5", len(seen))
+	}
+
+	s := randomParam{name: "sku", kind: "string", min: 8}.value()
+	if len(s) != 8 {
+		t.Errorf("got %q, want 8 characters", s)
+	}
+}
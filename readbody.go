@@ -0,0 +1,21 @@
+package main
+
+import "fmt"
+
+// bodyMode is how much of each response body is read
+type bodyMode string
+
+const (
+	bodyFull    bodyMode = "full"    // read in full, with the time taken included in the latency
+	bodyDiscard bodyMode = "discard" // drained once the latency is measured, so the connection can be re-used
+	bodyNone    bodyMode = "none"    // closed without being read, so only the headers are timed
+)
+
+// parseBodyMode parses the --read-body mode s
+func parseBodyMode(s string) (bodyMode, error) {
+	switch m := bodyMode(s); m {
+	case bodyFull, bodyDiscard, bodyNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid --read-body %q: must be full, discard or none", s)
+}
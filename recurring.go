@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xfxdev/xlog"
+)
+
+var (
+	scheduleEvery   time.Duration
+	scheduleResults string
+	scheduleRuns    int
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule [flags] [tag=]URL...",
+	Short: "Run a load test repeatedly, tracking the trend between runs",
+	Long: `Run a load test repeatedly, tracking the trend between runs.
+
+A load test is started every --every, and must stop by itself, so
+--duration or --requests is required. The summary of each run is appended to
+--results as a line of JSON, and compared with the previous run and with the
+mean of every run in the file, including those from earlier invocations, so
+that slow changes in performance stand out. A run which overruns --every is
+followed immediately by the next.
+
+All other flags apply to each run as they do to a normal load test.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger, cfg, err := setup(cmd, args)
+		if err != nil {
+			return err
+		}
+		if scheduleEvery <= 0 {
+			return errors.New("--every must be positive")
+		}
+		if !cfg.bounded() {
+			return errors.New("schedule requires --duration or --requests, so that each run stops")
+		}
+		return runSchedule(cmd.Context(), logger, cfg)
+	},
+}
+
+// scheduledRun is the summary of one run of a scheduled load test, as stored
+// in the results file
+type scheduledRun struct {
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_seconds"`
+	Rate     int       `json:"requests_per_second"`
+	Error    string    `json:"error,omitempty"`
+	jsonCounts
+}
+
+// runSchedule runs a load test of cfg every scheduleEvery, until ctx is
+// cancelled or scheduleRuns have been run
+func runSchedule(ctx context.Context, logger *xlog.Logger, cfg config) error {
+	history, err := readScheduledRuns(scheduleResults)
+	if err != nil {
+		return fmt.Errorf("unable to read results: %w", err)
+	}
+	if len(history) > 0 {
+		logger.Infof("Read %d earlier runs from %s", len(history), scheduleResults)
+	}
+
+	start := time.Now()
+	for n := 1; scheduleRuns <= 0 || n <= scheduleRuns; n++ {
+		logger.Infof("Starting run %d", n)
+		run, ok := runScheduled(ctx, logger, cfg)
+		if !ok {
+			logger.Infof("Interrupted, stopping")
+			return nil
+		}
+		if err := appendScheduledRun(scheduleResults, run); err != nil {
+			logger.Errorf("Unable to save the results of run %d to %s: %s", n, scheduleResults, err)
+		}
+		logTrend(logger, history, run)
+		history = append(history, run)
+
+		if scheduleRuns > 0 && n == scheduleRuns {
+			break
+		}
+		next := start.Add(time.Duration(n) * scheduleEvery)
+		logger.Infof("Next run at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Infof("Interrupted, stopping")
+			return nil
+		}
+	}
+	return nil
+}
+
+// runScheduled runs a single load test of cfg, returning its summary, or false
+// if it was interrupted. A run which fails or is aborted is still summarised,
+// with its error.
+func runScheduled(ctx context.Context, logger *xlog.Logger, cfg config) (scheduledRun, bool) {
+	run := scheduledRun{Start: time.Now(), Rate: cfg.rps}
+	cfg, err := runSetup(ctx, logger, cfg)
+	if err == nil {
+		var lt *loadTest
+		lt, err = newLoadTest(logger, cfg)
+		if err == nil {
+			err = lt.run(ctx)
+			if lt.interrupted {
+				return scheduledRun{}, false
+			}
+			lt.summary()
+			lt.writeReports(err)
+			run.jsonCounts = newJSONCounts(lt.stats.all.counts(), lt.stats.all.latencyHistogram(phaseTotal))
+		}
+	}
+	if ctx.Err() != nil {
+		return scheduledRun{}, false
+	}
+	if err != nil {
+		logger.Errorf("Run failed: %s", err)
+		run.Error = err.Error()
+	}
+	run.Duration = time.Since(run.Start).Seconds()
+	return run, true
+}
+
+// readScheduledRuns reads the runs saved in the results file at path, which
+// may not exist yet
+func readScheduledRuns(path string) ([]scheduledRun, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []scheduledRun
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var run scheduledRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, scanner.Err()
+}
+
+// appendScheduledRun appends run to the results file at path
+func appendScheduledRun(path string, run scheduledRun) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// trendMetric is a measure of a run which is compared between runs
+type trendMetric struct {
+	name   string
+	value  func(scheduledRun) (float64, bool)
+	format func(float64) string
+	points bool // true if changes are shown as a difference in percentage points
+}
+
+var trendMetrics = []trendMetric{
+	{name: "throughput", value: func(r scheduledRun) (float64, bool) {
+		return float64(r.Requests) / r.Duration, r.Duration > 0
+	}, format: func(v float64) string { return fmt.Sprintf("%.1f req/s", v) }},
+	{name: "error rate", value: func(r scheduledRun) (float64, bool) {
+		return 100 * float64(r.Failures) / float64(r.Requests), r.Requests > 0
+	}, format: func(v float64) string { return fmt.Sprintf("%.2f%%", v) }, points: true},
+	{name: "p50", value: latencyMetric("p50"), format: formatMillis},
+	{name: "p90", value: latencyMetric("p90"), format: formatMillis},
+	{name: "p99", value: latencyMetric("p99"), format: formatMillis},
+}
+
+// latencyMetric returns the latency percentile p of a run, in milliseconds
+func latencyMetric(p string) func(scheduledRun) (float64, bool) {
+	return func(r scheduledRun) (float64, bool) {
+		v, ok := r.Latencies[p]
+		return v, ok
+	}
+}
+
+// formatMillis formats a latency in milliseconds
+func formatMillis(ms float64) string {
+	return fmt.Sprintf("%.2fms", ms)
+}
+
+// logTrend logs how run compares with the last of the earlier runs in
+// history, and with the mean of all of them
+func logTrend(logger *xlog.Logger, history []scheduledRun, run scheduledRun) {
+	if len(history) == 0 {
+		logger.Infof("Trend: this is the first run, so there is nothing to compare it with")
+		return
+	}
+
+	logger.Infof("Trend compared with the last run at %s and the mean of %d runs:", history[len(history)-1].Start.Format(time.RFC3339), len(history))
+	for _, m := range trendMetrics {
+		v, ok := m.value(run)
+		if !ok {
+			continue
+		}
+		line := fmt.Sprintf("  %-10s %s", m.name, m.format(v))
+		if last, ok := m.value(history[len(history)-1]); ok {
+			line += ", " + m.change(v, last) + " on the last run"
+		}
+
+		var sum float64
+		var n int
+		for _, r := range history {
+			if v, ok := m.value(r); ok {
+				sum += v
+				n++
+			}
+		}
+		if n > 0 {
+			line += ", " + m.change(v, sum/float64(n)) + " on the mean"
+		}
+		logger.Infof("%s", line)
+	}
+}
+
+// change describes the change from was to v
+func (m trendMetric) change(v, was float64) string {
+	if m.points {
+		return fmt.Sprintf("%+.2f points", v-was)
+	}
+	if was == 0 {
+		return "was " + m.format(was)
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(v-was)/was)
+}
+
+func init() {
+	scheduleCmd.Flags().DurationVar(&scheduleEvery, "every", time.Hour, "how often to start a run")
+	scheduleCmd.Flags().StringVar(&scheduleResults, "results", "slt-results.jsonl", "file to append the summary of each run to, and to read earlier runs from")
+	scheduleCmd.Flags().IntVar(&scheduleRuns, "runs", 0, "number of runs before stopping, or 0 to run until interrupted")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// sustainedRateThreshold is the fraction of the requested rate below which
+// the rate is reported as not sustained
+const sustainedRateThreshold = 0.9
+
+// reporter periodically logs the statistics of a load test, along with the
+// rates over the last interval
+type reporter struct {
+	lt            *loadTest
+	logger        *xlog.Logger
+	lastActive    time.Duration
+	last          map[string]counts // by the prefix of the line they are logged on
+	lastRequested int64
+	lastStarted   int64
+	lastSkipped   int64
+}
+
+// newReporter returns a reporter for lt which logs to logger, with rates
+// measured from the start of the load test
+func newReporter(lt *loadTest, logger *xlog.Logger) *reporter {
+	return &reporter{lt: lt, logger: logger, last: map[string]counts{}}
+}
+
+// run reports every interval until stop is closed
+func (r *reporter) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.report()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// report logs the statistics gathered so far. Rates are over the time since
+// the last report that the load test was sending requests, not counting time
+// paused or draining those in flight at the end.
+func (r *reporter) report() {
+	lt := r.lt
+	active := lt.activeFor()
+	elapsed := active - r.lastActive
+	r.lastActive = active
+	if elapsed <= 0 {
+		r.logger.Infof("Paused, no requests sent")
+		return
+	}
+
+	r.logCounts(&lt.stats.all, "", elapsed)
+	r.logRate(elapsed)
+	lt.stats.all.logLatencies(r.logger, "")
+	if lt.monitor != nil {
+		lt.monitor.log(r.logger)
+	}
+
+	if len(lt.stats.tags) > 1 {
+		for _, tag := range lt.stats.tags {
+			s := lt.stats.byTag[tag]
+			r.logCounts(s, tag+": ", elapsed)
+			s.logLatencies(r.logger, tag+": ", phaseTotal, phaseIntended)
+		}
+	}
+	for _, segment := range lt.stats.segments {
+		s := lt.stats.bySegment[segment]
+		r.logCounts(s, "["+segment+"] ", elapsed)
+		s.logLatencies(r.logger, "["+segment+"] ", phaseTotal, phaseIntended)
+	}
+}
+
+// logRate logs the rate at which requests were started since the last
+// report, compared to the target rate, warning if the requested rate
+// wasn't sustained, and how many requests were skipped as the load generator
+// was saturated
+func (r *reporter) logRate(elapsed time.Duration) {
+	requested, started := r.lt.requestedAndStarted()
+	skipped := r.lt.skipped()
+	intervalRequested, intervalStarted, intervalSkipped := requested-r.lastRequested, started-r.lastStarted, skipped-r.lastSkipped
+	r.lastRequested, r.lastStarted, r.lastSkipped = requested, started, skipped
+	if intervalRequested == 0 {
+		return
+	}
+
+	secs := elapsed.Seconds()
+	achieved := float64(intervalStarted) / float64(intervalRequested)
+	r.logger.Infof("Achieved %.1f req/s of the %.1f req/s targeted (%.0f%%)", float64(intervalStarted)/secs, float64(intervalRequested)/secs, 100*achieved)
+	if intervalSkipped > 0 {
+		r.logger.Warnf("Skipped %d requests as the load generator was saturated, with every worker busy and the queue full", intervalSkipped)
+	}
+	if achieved < sustainedRateThreshold {
+		r.logger.Warnf("The requested rate was not sustained, so the results understate the load; the workers, the load generator or a slow target may be the bottleneck")
+	}
+}
+
+// logCounts logs the request counts in s, and the rates since they were
+// last logged, with the line starting with prefix
+func (r *reporter) logCounts(s *stats, prefix string, elapsed time.Duration) {
+	c := s.counts()
+	interval := c.sub(r.last[prefix])
+	r.last[prefix] = c
+
+	secs := elapsed.Seconds()
+	rates := fmt.Sprintf("%.1f req/s, %.1f failures/s", float64(interval.sent())/secs, float64(interval.failed)/secs)
+	if r.lt.cfg.readBody != bodyNone {
+		rates += fmt.Sprintf(", %s/s received", formatBytes(uint64(float64(interval.bytes)/secs)))
+	}
+	rates += fmt.Sprintf(" over the last %v", elapsed.Round(time.Millisecond))
+	if r.lt.cfg.followRedirects {
+		r.logger.Infof("%sSent %d requests, %d ok, %d failures, %d redirects followed (%s)", prefix, c.sent(), c.ok, c.failed, c.redirects, rates)
+	} else {
+		r.logger.Infof("%sSent %d requests, %d ok, %d failures (%s)", prefix, c.sent(), c.ok, c.failed, rates)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// reportFormats are the formats of report file that can be written when a
+// load test finishes, by name. Each writer is given the error, if any, that
+// the load test finished with.
+var reportFormats = map[string]func(w io.Writer, lt *loadTest, err error) error{
+	"csv":        writeCSV,
+	"json":       writeJSON,
+	"junit":      writeJUnit,
+	"k6-summary": writeK6Summary,
+	"plot":       writePlot,
+}
+
+// outcome is the result of one of the checks made of a load test, as shown
+// in reports
+type outcome struct {
+	group   string // the tag or kind of check, if any
+	name    string
+	passed  bool
+	errored bool   // true if the load test failed with an error
+	message string // why the check failed
+}
+
+// outcomes returns the outcome of each check made of lt, which finished with
+// err. The load test must complete without a fatal error, the requests to
+// each tag must all pass the response checks, any --expect-codes must be
+// returned, no abort rule may abort the load test, and each named check must
+// hold over the whole load test.
+func (lt *loadTest) outcomes(err error) []outcome {
+	run := outcome{name: "load test completes", passed: true}
+	if _, ok := err.(abortError); err != nil && !ok {
+		run = outcome{name: run.name, errored: true, message: err.Error()}
+	}
+	outcomes := []outcome{run}
+
+	for _, tag := range lt.stats.tags {
+		c := lt.stats.byTag[tag].counts()
+		o := outcome{group: tag, name: "responses are OK", passed: c.failed == 0}
+		if !o.passed {
+			o.message = fmt.Sprintf("%d of %d requests failed the response checks", c.failed, c.sent())
+		}
+		outcomes = append(outcomes, o)
+	}
+
+	if len(lt.cfg.expectCodes) > 0 {
+		codes := formatCodes(lt.cfg.expectCodes)
+		o := outcome{group: "expected codes", name: codes + " returned", passed: lt.stats.all.counts().expected > 0}
+		if !o.passed {
+			o.message = "the target never returned " + codes
+		}
+		outcomes = append(outcomes, o)
+	}
+
+	aborted, _ := err.(abortError)
+	for _, r := range lt.cfg.abortRules {
+		o := outcome{group: "abort", name: "abort-on " + r.rule, passed: aborted.rule != r.rule}
+		if !o.passed {
+			o.message = aborted.reason
+		}
+		outcomes = append(outcomes, o)
+	}
+	if lt.checker != nil {
+		outcomes = append(outcomes, lt.checker.outcomes()...)
+	}
+	return outcomes
+}
+
+// reportFile is a report to write when a load test finishes
+type reportFile struct {
+	format string
+	path   string
+}
+
+// parseReportFile parses a report of the form "FORMAT:PATH", such as
+// "junit:results.xml"
+func parseReportFile(s string) (reportFile, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return reportFile{}, fmt.Errorf("invalid report %q: expected FORMAT:PATH", s)
+	}
+	if _, ok := reportFormats[parts[0]]; !ok {
+		var formats []string
+		for f := range reportFormats {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		return reportFile{}, fmt.Errorf("invalid report %q: format must be one of %s", s, strings.Join(formats, ", "))
+	}
+	return reportFile{format: parts[0], path: parts[1]}, nil
+}
+
+// write writes the report for lt, which finished with err
+func (r reportFile) write(lt *loadTest, err error) error {
+	f, createErr := os.Create(r.path)
+	if createErr != nil {
+		return createErr
+	}
+	if writeErr := reportFormats[r.format](f, lt, err); writeErr != nil {
+		f.Close()
+		return writeErr
+	}
+	return f.Close()
+}
+
+// writeReports writes each of the load test's report files, logging any that
+// can't be written
+func (lt *loadTest) writeReports(err error) {
+	for _, r := range lt.cfg.reports {
+		if writeErr := r.write(lt, err); writeErr != nil {
+			lt.logger.Errorf("Unable to write %s report to %s: %s", r.format, r.path, writeErr)
+			continue
+		}
+		lt.logger.Infof("Wrote %s report to %s", r.format, r.path)
+	}
+}
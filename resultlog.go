@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultEncoder encodes the result of each request to a stream
+type resultEncoder interface {
+	// encode encodes the result r of a request which completed at elapsed
+	// after the load test started at start
+	encode(start time.Time, elapsed time.Duration, r result) error
+
+	// flush writes any buffered results
+	flush() error
+}
+
+// resultFormats are the formats that raw results can be written in, by name
+var resultFormats = map[string]func(w io.Writer) resultEncoder{
+	"csv":         newCSVResultEncoder,
+	"vegeta":      newVegetaGobEncoder,
+	"vegeta-json": newVegetaJSONEncoder,
+}
+
+// resultFile is a file to stream the result of every request to
+type resultFile struct {
+	format string
+	path   string
+}
+
+// parseResultFile parses a results file of the form "FORMAT:PATH", such as
+// "vegeta:results.bin"
+func parseResultFile(s string) (resultFile, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return resultFile{}, fmt.Errorf("invalid --out %q: expected FORMAT:PATH", s)
+	}
+	if _, ok := resultFormats[parts[0]]; !ok {
+		var formats []string
+		for f := range resultFormats {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		return resultFile{}, fmt.Errorf("invalid --out %q: format must be one of %s", s, strings.Join(formats, ", "))
+	}
+	return resultFile{format: parts[0], path: parts[1]}, nil
+}
+
+// resultLog streams the result of every request to a file as it completes,
+// so that they can be analysed afterwards without being held in memory. It
+// is safe for concurrent use.
+type resultLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	enc    resultEncoder
+	err    error // the first error encoding a result
+	closed bool
+}
+
+// newResultLog creates the file for rf, ready to write results to
+func newResultLog(rf resultFile) (*resultLog, error) {
+	f, err := os.Create(rf.path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &resultLog{f: f, w: w, enc: resultFormats[rf.format](w)}, nil
+}
+
+// write writes the result of a request which completed at elapsed after the
+// load test started at start. Results which arrive after the file is closed
+// are dropped.
+func (rl *resultLog) write(start time.Time, elapsed time.Duration, r result) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if !rl.closed && rl.err == nil {
+		rl.err = rl.enc.encode(start, elapsed, r)
+	}
+}
+
+// close flushes and closes the file, returning the first error writing to it
+func (rl *resultLog) close() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.closed = true
+	err := rl.err
+	if err == nil {
+		err = rl.enc.flush()
+	}
+	if err == nil {
+		err = rl.w.Flush()
+	}
+	if closeErr := rl.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// csvResultEncoder writes results as CSV, with a header row
+type csvResultEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVResultEncoder(w io.Writer) resultEncoder {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"elapsed_ms", "id", "tag", "segment", "ok", "latency_ms", "bytes"})
+	return &csvResultEncoder{w: cw}
+}
+
+func (e *csvResultEncoder) encode(start time.Time, elapsed time.Duration, r result) error {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	return e.w.Write([]string{ms(elapsed), r.id, r.tag, r.segment, strconv.FormatBool(r.ok), ms(r.timings[phaseTotal]), strconv.FormatInt(r.bytes, 10)})
+}
+
+func (e *csvResultEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// vegetaResult has the fields of vegeta's Result, so that its report, plot
+// and hist commands can read our results. The field names must match for gob
+// to decode them.
+type vegetaResult struct {
+	Attack    string        `json:"attack"`
+	Seq       uint64        `json:"seq"`
+	Code      uint16        `json:"code"`
+	Timestamp time.Time     `json:"timestamp"`
+	Latency   time.Duration `json:"latency"`
+	BytesOut  uint64        `json:"bytes_out"`
+	BytesIn   uint64        `json:"bytes_in"`
+	Error     string        `json:"error"`
+	Body      []byte        `json:"body"`
+	Method    string        `json:"method"`
+	URL       string        `json:"url"`
+	Headers   http.Header   `json:"headers"`
+}
+
+// newVegetaResult returns r as a vegeta result, numbered seq. The tag is used
+// as the attack name, and the time the request started as its timestamp.
+func newVegetaResult(seq uint64, start time.Time, elapsed time.Duration, r result) vegetaResult {
+	latency := r.timings[phaseTotal]
+	return vegetaResult{
+		Attack:    r.tag,
+		Seq:       seq,
+		Code:      uint16(r.code),
+		Timestamp: start.Add(elapsed - latency),
+		Latency:   latency,
+		BytesOut:  uint64(r.bytesOut),
+		BytesIn:   uint64(r.bytes),
+		Error:     r.err,
+		Method:    r.method,
+		URL:       r.url,
+	}
+}
+
+// vegetaGobEncoder writes results in vegeta's default gob encoding
+type vegetaGobEncoder struct {
+	enc *gob.Encoder
+	seq uint64
+}
+
+func newVegetaGobEncoder(w io.Writer) resultEncoder {
+	return &vegetaGobEncoder{enc: gob.NewEncoder(w)}
+}
+
+func (e *vegetaGobEncoder) encode(start time.Time, elapsed time.Duration, r result) error {
+	v := newVegetaResult(e.seq, start, elapsed, r)
+	e.seq++
+	return e.enc.Encode(&v)
+}
+
+func (e *vegetaGobEncoder) flush() error {
+	return nil
+}
+
+// vegetaJSONEncoder writes results in vegeta's JSON encoding, one per line
+type vegetaJSONEncoder struct {
+	enc *json.Encoder
+	seq uint64
+}
+
+func newVegetaJSONEncoder(w io.Writer) resultEncoder {
+	return &vegetaJSONEncoder{enc: json.NewEncoder(w)}
+}
+
+func (e *vegetaJSONEncoder) encode(start time.Time, elapsed time.Duration, r result) error {
+	v := newVegetaResult(e.seq, start, elapsed, r)
+	e.seq++
+	return e.enc.Encode(&v)
+}
+
+func (e *vegetaJSONEncoder) flush() error {
+	return nil
+}
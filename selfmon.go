@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// Thresholds above which the load generator is considered to be the
+// bottleneck of a load test
+const (
+	maxGeneratorCPU     = 0.9
+	maxGeneratorFDRatio = 0.9
+)
+
+// generatorSample is a measurement of the load generator's own resource usage
+type generatorSample struct {
+	cpu        float64 // fraction of all CPUs used since the last sample
+	heapBytes  uint64
+	sysBytes   uint64
+	goroutines int
+	openFDs    int // -1 if unknown
+	maxFDs     int // -1 if unknown
+}
+
+// String formats the sample for logging
+func (s generatorSample) String() string {
+	fds := "unknown"
+	if s.openFDs >= 0 {
+		fds = fmt.Sprint(s.openFDs)
+		if s.maxFDs >= 0 {
+			fds += fmt.Sprintf("/%d", s.maxFDs)
+		}
+	}
+	return fmt.Sprintf("CPU %.1f%%, heap %s, memory %s, %d goroutines, %s open files",
+		s.cpu*100, formatBytes(s.heapBytes), formatBytes(s.sysBytes), s.goroutines, fds)
+}
+
+// selfMonitor periodically samples the resource usage of the load generator
+// during a soak test, and warns when the generator itself is likely to be the
+// bottleneck. It is safe for concurrent use.
+type selfMonitor struct {
+	logger *xlog.Logger
+
+	mu           sync.Mutex
+	samples      int
+	latest       generatorSample
+	peak         generatorSample
+	lastCPU      time.Duration
+	lastTime     time.Time
+	bottlenecked bool
+}
+
+// newSelfMonitor returns a monitor which logs warnings to logger
+func newSelfMonitor(logger *xlog.Logger) *selfMonitor {
+	m := &selfMonitor{logger: logger, lastTime: time.Now()}
+	m.lastCPU, _ = cpuTime()
+	return m
+}
+
+// run takes a sample every interval until stop is closed
+func (m *selfMonitor) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.sample()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sample measures the current resource usage of the load generator
+func (m *selfMonitor) sample() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	s := generatorSample{
+		heapBytes:  mem.HeapAlloc,
+		sysBytes:   mem.Sys,
+		goroutines: runtime.NumGoroutine(),
+	}
+	if cpu, ok := cpuTime(); ok {
+		s.cpu = (cpu - m.lastCPU).Seconds() / now.Sub(m.lastTime).Seconds() / float64(runtime.NumCPU())
+		m.lastCPU = cpu
+	}
+	m.lastTime = now
+	s.openFDs, s.maxFDs = fdUsage()
+
+	m.samples++
+	m.latest = s
+	m.peak = generatorSample{
+		cpu:        maxFloat(m.peak.cpu, s.cpu),
+		heapBytes:  maxUint64(m.peak.heapBytes, s.heapBytes),
+		sysBytes:   maxUint64(m.peak.sysBytes, s.sysBytes),
+		goroutines: maxInt(m.peak.goroutines, s.goroutines),
+		openFDs:    maxInt(m.peak.openFDs, s.openFDs),
+		maxFDs:     s.maxFDs,
+	}
+
+	// Only warn when the generator becomes the bottleneck, rather than on
+	// every sample
+	reason := bottleneck(s)
+	if reason != "" && !m.bottlenecked {
+		m.logger.Warnf("The load generator may be the bottleneck (%s), so results may not reflect the target's performance", reason)
+	} else if reason == "" && m.bottlenecked {
+		m.logger.Infof("The load generator is no longer the bottleneck")
+	}
+	m.bottlenecked = reason != ""
+}
+
+// bottleneck returns the reason the generator is the bottleneck, or an
+// empty string if it is not
+func bottleneck(s generatorSample) string {
+	if s.cpu >= maxGeneratorCPU {
+		return fmt.Sprintf("CPU usage is %.0f%%", s.cpu*100)
+	}
+	if s.openFDs >= 0 && s.maxFDs > 0 && float64(s.openFDs) >= maxGeneratorFDRatio*float64(s.maxFDs) {
+		return fmt.Sprintf("%d of %d files are open", s.openFDs, s.maxFDs)
+	}
+	return ""
+}
+
+// log logs the latest sample, if one has been taken
+func (m *selfMonitor) log(logger *xlog.Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.samples == 0 {
+		return
+	}
+	logger.Infof("Generator: %s", m.latest)
+}
+
+// logPeak logs the peak usage of each resource, if a sample has been taken
+func (m *selfMonitor) logPeak(logger *xlog.Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.samples == 0 {
+		return
+	}
+	logger.Infof("Generator peak: %s", m.peak)
+}
+
+// formatBytes formats n bytes in human readable units
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// maxInt returns the larger of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// maxUint64 returns the larger of a and b
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
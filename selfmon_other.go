@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package main
+
+import "time"
+
+// cpuTime returns the total CPU time used by the process, which is not
+// supported on this platform
+func cpuTime() (time.Duration, bool) {
+	return 0, false
+}
+
+// fdUsage returns the number of open file descriptors and the maximum the
+// process may open, which is not supported on this platform
+func fdUsage() (open, max int) {
+	return -1, -1
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// cpuTime returns the total user and system CPU time used by the process
+func cpuTime() (time.Duration, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, false
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), true
+}
+
+// fdUsage returns the number of open file descriptors and the maximum the
+// process may open, or -1 for either if it is unknown
+func fdUsage() (open, max int) {
+	open, max = -1, -1
+
+	// Reading the directory opens a file descriptor, which isn't counted
+	if entries, err := os.ReadDir("/dev/fd"); err == nil {
+		open = len(entries) - 1
+	}
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+		max = int(limit.Cur)
+	}
+	return open, max
+}
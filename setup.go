@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xfxdev/xlog"
+)
+
+// extraction takes a value from the response to the setup request, to be
+// used as ${name} in the load test's requests
+type extraction struct {
+	name   string
+	source string // one of json, header or regexp
+	expr   string
+}
+
+// parseExtraction parses an extraction of the form "NAME=SOURCE:EXPR", such
+// as "token=json:data.access_token", "session=header:X-Session" or
+// "csrf=regexp:csrf=([a-z0-9]+)"
+func parseExtraction(s string) (extraction, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return extraction{}, fmt.Errorf("invalid extraction %q: expected NAME=SOURCE:EXPR", s)
+	}
+	parts := strings.SplitN(s[i+1:], ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return extraction{}, fmt.Errorf("invalid extraction %q: expected NAME=SOURCE:EXPR", s)
+	}
+
+	e := extraction{name: s[:i], source: parts[0], expr: parts[1]}
+	switch e.source {
+	case "json", "header":
+	case "regexp":
+		re, err := regexp.Compile(e.expr)
+		if err != nil {
+			return extraction{}, fmt.Errorf("invalid extraction %q: %w", s, err)
+		}
+		if re.NumSubexp() != 1 {
+			return extraction{}, fmt.Errorf("invalid extraction %q: the regexp must have exactly one group", s)
+		}
+	default:
+		return extraction{}, fmt.Errorf("invalid extraction %q: source must be json, header or regexp", s)
+	}
+	return e, nil
+}
+
+// extract returns the value of e from the response with the given headers
+// and body
+func (e extraction) extract(h http.Header, body []byte) (string, error) {
+	switch e.source {
+	case "header":
+		if v := h.Get(e.expr); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("no %s header in the response", e.expr)
+	case "regexp":
+		m := regexp.MustCompile(e.expr).FindSubmatch(body)
+		if m == nil {
+			return "", fmt.Errorf("%q did not match the response", e.expr)
+		}
+		return string(m[1]), nil
+	default:
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return "", fmt.Errorf("response is not JSON: %w", err)
+		}
+		return jsonPath(v, e.expr)
+	}
+}
+
+// jsonPath returns the value at path in v, a decoded JSON value. The path is
+// a list of object keys and array indexes separated by dots, such as
+// "data.items.0.id".
+func jsonPath(v interface{}, path string) (string, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("no %q in the response at %q", key, path)
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("no index %q in the response at %q", key, path)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("no %q in the response at %q", key, path)
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("%q is not a single value in the response", path)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// varPattern matches a ${name} reference to an extracted value
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// expandVars replaces each ${name} in s with its value in vars. References
+// to unknown names are left as they are.
+func expandVars(s string, vars map[string]string) string {
+	return varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := vars[ref[2:len(ref)-1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// runSetup sends the setup request, if there is one, and returns cfg with
+// the values extracted from its response substituted into the URLs, headers
+// and bodies of the targets and the --headers
+func runSetup(ctx context.Context, logger *xlog.Logger, cfg config) (config, error) {
+	if cfg.setup == nil {
+		return cfg, nil
+	}
+
+	t := *cfg.setup
+	logger.Infof("Running setup request %s %s", t.method, t.url)
+	req, err := http.NewRequestWithContext(ctx, t.method, t.url, bytes.NewReader(t.body))
+	if err != nil {
+		return cfg, fmt.Errorf("setup failed: %w", err)
+	}
+	req.Header.Set("User-Agent", cfg.userAgent)
+	for key, vals := range t.headers {
+		req.Header[key] = vals
+	}
+
+	// Redirects are followed, such as from a login form, with the cookies set
+	// by each response sent on with the next request
+	var hops []http.Header
+	client := newClient(cfg, nil)
+	client.Jar, _ = cookiejar.New(nil) // which never fails
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > cfg.maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", cfg.maxRedirects)
+		}
+		hops = append(hops, req.Response.Header)
+		return nil
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return cfg, fmt.Errorf("setup failed: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return cfg, fmt.Errorf("setup failed: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return cfg, fmt.Errorf("setup failed: got %q", resp.Status)
+	}
+	if len(hops) > 0 {
+		logger.Debugf("Followed %d redirects to %s", len(hops), resp.Request.URL)
+	}
+
+	// Headers are extracted from the last response which has them, so that
+	// a cookie set before a redirect can still be used
+	header := resp.Header.Clone()
+	for i := len(hops) - 1; i >= 0; i-- {
+		for key, vals := range hops[i] {
+			if _, ok := header[key]; !ok {
+				header[key] = vals
+			}
+		}
+	}
+
+	vars := map[string]string{}
+	for _, e := range cfg.extractions {
+		v, err := e.extract(header, body)
+		if err != nil {
+			return cfg, fmt.Errorf("setup failed: unable to extract %s: %w", e.name, err)
+		}
+		vars[e.name] = v
+		logger.Debugf("Extracted %s from the setup response", e.name)
+	}
+
+	headers := map[string]string{}
+	for key, val := range cfg.headers {
+		headers[key] = expandVars(val, vars)
+	}
+	cfg.headers = headers
+
+	targets := make([]target, len(cfg.targets))
+	for i, t := range cfg.targets {
+		t.url = expandVars(t.url, vars)
+		if t.headers != nil {
+			h := http.Header{}
+			for key, vals := range t.headers {
+				for _, val := range vals {
+					h.Add(key, expandVars(val, vars))
+				}
+			}
+			t.headers = h
+		}
+		if t.body != nil {
+			t.body = []byte(expandVars(string(t.body), vars))
+		}
+		targets[i] = t
+	}
+	cfg.targets = targets
+	return cfg, nil
+}
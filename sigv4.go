@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+
+	// sigV4RefreshBefore is how long before credentials expire that they are
+	// refreshed
+	sigV4RefreshBefore = 5 * time.Minute
+)
+
+// awsScope is the region and service requests are signed for
+type awsScope struct {
+	region  string
+	service string
+}
+
+// parseAWSScope parses an --aws-sigv4 of the form "REGION/SERVICE", such as
+// "eu-west-1/execute-api"
+func parseAWSScope(s string) (awsScope, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return awsScope{}, fmt.Errorf("invalid --aws-sigv4 %q: expected REGION/SERVICE, such as eu-west-1/execute-api", s)
+	}
+	return awsScope{region: parts[0], service: parts[1]}, nil
+}
+
+// sigV4Signer signs requests with AWS Signature Version 4, using credentials
+// from the default chain which are refreshed before they expire. It is safe
+// for concurrent use.
+type sigV4Signer struct {
+	scope awsScope
+
+	mu     sync.Mutex
+	creds  awsCredentials
+	source string // where the credentials came from
+}
+
+// newSigV4Signer returns a signer for scope, failing if no credentials can be
+// found
+func newSigV4Signer(scope awsScope) (*sigV4Signer, error) {
+	creds, source, err := findAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return &sigV4Signer{scope: scope, creds: creds, source: source}, nil
+}
+
+// credentials returns the credentials to sign with, refreshing them if they
+// are about to expire. Expiring credentials which can't be refreshed are used
+// until they expire.
+func (s *sigV4Signer) credentials(now time.Time) (awsCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.creds.expires.IsZero() || now.Add(sigV4RefreshBefore).Before(s.creds.expires) {
+		return s.creds, nil
+	}
+	creds, source, err := findAWSCredentials()
+	if err == nil {
+		s.creds, s.source = creds, source
+	} else if !now.Before(s.creds.expires) {
+		return awsCredentials{}, fmt.Errorf("AWS credentials expired and could not be refreshed: %w", err)
+	}
+	return s.creds, nil
+}
+
+// sign signs req, which must not have been sent yet. The payload is hashed
+// for every request, so bodies which differ between requests are signed
+// correctly. A body which can't be read twice is read into memory.
+func (s *sigV4Signer) sign(req *http.Request) error {
+	return s.signAt(req, time.Now().UTC())
+}
+
+// signAt signs req as if at now
+func (s *sigV4Signer) signAt(req *http.Request, now time.Time) error {
+	creds, err := s.credentials(now)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		payload, err = io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	default:
+		payload, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(payload))
+		req.ContentLength = int64(len(payload))
+	}
+	payloadHash := sha256Hex(payload)
+
+	amzDate := now.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	} else {
+		req.Header.Del("X-Amz-Security-Token")
+	}
+
+	// The host, content type and any x-amz- headers are signed
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	signed := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			for i, v := range values {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			signed[name] = strings.Join(values, ",")
+		}
+	}
+	var names []string
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// S3 paths are encoded once, and those of every other service twice
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if s.scope.service != "s3" {
+		path = sigV4Escape(path, false)
+	}
+
+	canonical := strings.Join([]string{req.Method, path, sigV4Query(req), headers.String(), signedHeaders, payloadHash}, "\n")
+	date := now.Format("20060102")
+	scope := strings.Join([]string{date, s.scope.region, s.scope.service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{date, s.scope.region, s.scope.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", sigV4Algorithm, creds.accessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigV4Query returns the canonical query string of req, with the parameters
+// sorted by name and then value
+func sigV4Query(req *http.Request) string {
+	var params [][2]string
+	for name, values := range req.URL.Query() {
+		for _, v := range values {
+			params = append(params, [2]string{sigV4Escape(name, true), sigV4Escape(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes every byte of s except the unreserved
+// characters, and slashes unless escapeSlash is true
+func sigV4Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of b
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sink is a destination that metrics are pushed to while a load test runs
+type sink interface {
+	// push sends the metrics of lt at now
+	push(lt *loadTest, now time.Time) error
+}
+
+// sinkKinds are the kinds of sink that metrics can be pushed to, by name.
+// Each is created from the target in its --sink.
+var sinkKinds = map[string]func(target string) (sink, error){
+	"prom-remote-write": newPromRemoteWrite,
+}
+
+// sinkSpec is a sink to push metrics to, as given by --sink. A new sink is
+// created from it for each load test, as sinks have state.
+type sinkSpec struct {
+	kind   string
+	target string
+}
+
+// parseSinkSpec parses a sink of the form "KIND://TARGET", such as
+// "prom-remote-write://http://mimir:9009/api/v1/push"
+func parseSinkSpec(s string) (sinkSpec, error) {
+	parts := strings.SplitN(s, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return sinkSpec{}, fmt.Errorf("invalid sink %q: expected KIND://TARGET", s)
+	}
+	if _, ok := sinkKinds[parts[0]]; !ok {
+		var kinds []string
+		for k := range sinkKinds {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		return sinkSpec{}, fmt.Errorf("invalid sink %q: kind must be one of %s", s, strings.Join(kinds, ", "))
+	}
+	spec := sinkSpec{kind: parts[0], target: parts[1]}
+	if _, err := spec.new(); err != nil {
+		return sinkSpec{}, fmt.Errorf("invalid sink %q: %w", s, err)
+	}
+	return spec, nil
+}
+
+// new returns a new sink for spec
+func (spec sinkSpec) new() (sink, error) {
+	return sinkKinds[spec.kind](spec.target)
+}
+
+// String returns the sink as it was given
+func (spec sinkSpec) String() string {
+	return spec.kind + "://" + spec.target
+}
+
+// runSinks pushes metrics to each sink every interval until stop is closed,
+// then pushes them a final time and closes done. A failed push is logged, and
+// the next is tried as usual.
+func (lt *loadTest) runSinks(sinks []sink, interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	push := func() {
+		now := time.Now()
+		for i, s := range sinks {
+			if err := s.push(lt, now); err != nil {
+				lt.logger.Warnf("Unable to push metrics to %s: %s", lt.cfg.sinks[i], err)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			push()
+		case <-stop:
+			push()
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Segments of a load test with spikes
+const (
+	segmentBaseline = "baseline"
+	segmentSpike    = "spike"
+)
+
+// spike describes a periodic spike in the request rate. Every interval, the
+// rate is multiplied by multiplier for length, before returning to the
+// baseline rate.
+type spike struct {
+	multiplier float64
+	length     time.Duration
+	interval   time.Duration
+}
+
+// parseSpike parses a spike of the form "MULTIPLIERx:LENGTH", such as
+// "10x:30s", which repeats every interval
+func parseSpike(s string, interval time.Duration) (spike, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || !strings.HasSuffix(parts[0], "x") {
+		return spike{}, fmt.Errorf("invalid spike %q: expected the form \"10x:30s\"", s)
+	}
+
+	multiplier, err := strconv.ParseFloat(strings.TrimSuffix(parts[0], "x"), 64)
+	if err != nil || multiplier <= 0 {
+		return spike{}, fmt.Errorf("invalid spike %q: multiplier must be a positive number", s)
+	}
+	length, err := time.ParseDuration(parts[1])
+	if err != nil || length <= 0 {
+		return spike{}, fmt.Errorf("invalid spike %q: length must be a positive duration", s)
+	}
+	if length >= interval {
+		return spike{}, fmt.Errorf("invalid spike %q: length must be shorter than the spike interval of %v", s, interval)
+	}
+
+	return spike{multiplier: multiplier, length: length, interval: interval}, nil
+}
+
+// active returns true if a spike is in progress at elapsed after the load
+// test started. The first spike starts after one interval of baseline load.
+func (s spike) active(elapsed time.Duration) bool {
+	if s.interval <= 0 || elapsed < s.interval {
+		return false
+	}
+	return elapsed%s.interval < s.length
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// significanceLevel is the p-value below which a difference between split
+// targets is reported as significant
+const significanceLevel = 0.05
+
+// parseSplit parses a --split of the form "URL=WEIGHT,URL=WEIGHT", returning
+// a target for each URL, tagged with the URL
+func parseSplit(s string) ([]target, error) {
+	var targets []target
+	for _, part := range strings.Split(s, ",") {
+		i := strings.LastIndex(part, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid --split %q: expected URL=WEIGHT", part)
+		}
+		t, err := parseTarget(part[:i])
+		if err != nil {
+			return nil, err
+		}
+		t.tag = t.url
+		if t.weight, err = strconv.Atoi(part[i+1:]); err != nil || t.weight <= 0 {
+			return nil, fmt.Errorf("invalid --split %q: weight must be a positive integer", part)
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) < 2 {
+		return nil, fmt.Errorf("invalid --split %q: expected at least 2 URLs", s)
+	}
+	return targets, nil
+}
+
+// logComparison logs the latency and error rate of each split target next to
+// those of the first, flagging any statistically significant differences
+func (lt *loadTest) logComparison(logger *xlog.Logger) {
+	baseTag := lt.stats.tags[0]
+	base := lt.stats.byTag[baseTag]
+	baseCounts, baseLatencies := base.counts(), base.latencyHistogram(phaseTotal)
+
+	for _, tag := range lt.stats.tags[1:] {
+		s := lt.stats.byTag[tag]
+		c, latencies := s.counts(), s.latencyHistogram(phaseTotal)
+		logger.Infof("Comparison of %s with %s:", tag, baseTag)
+
+		p := twoProportionTest(baseCounts.failed, baseCounts.sent(), c.failed, c.sent())
+		logger.Infof("  error rate %.2f%% vs %.2f%% (%s)", errorRate(c), errorRate(baseCounts), describeSignificance(p))
+
+		if latencies.count() == 0 || baseLatencies.count() == 0 {
+			continue
+		}
+		for _, pct := range []float64{50, 90, 99} {
+			logger.Infof("  p%-2.0f       %v vs %v", pct, latencies.percentile(pct).Round(time.Microsecond), baseLatencies.percentile(pct).Round(time.Microsecond))
+		}
+		p, slower := mannWhitney(baseLatencies, latencies)
+		logger.Infof("  a request to %s was slower than one to %s %.0f%% of the time (%s)", tag, baseTag, 100*slower, describeSignificance(p))
+	}
+}
+
+// errorRate returns the percentage of the requests in c that failed
+func errorRate(c counts) float64 {
+	if c.sent() == 0 {
+		return 0
+	}
+	return 100 * float64(c.failed) / float64(c.sent())
+}
+
+// describeSignificance describes the p-value p of a difference
+func describeSignificance(p float64) string {
+	if p < significanceLevel {
+		return fmt.Sprintf("p=%.3f, significant", p)
+	}
+	return fmt.Sprintf("p=%.3f, not significant", p)
+}
+
+// twoProportionTest returns the two-sided p-value of a z-test that the
+// proportions x1 of n1 and x2 of n2 are the same
+func twoProportionTest(x1, n1, x2, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	pooled := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+	z := (float64(x2)/float64(n2) - float64(x1)/float64(n1)) / se
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// mannWhitney returns the two-sided p-value of a Mann-Whitney U test that
+// the samples counted in a and b come from the same distribution, using the
+// normal approximation, along with the probability that a value from b is
+// greater than one from a. Values in the same bucket are treated as ties.
+func mannWhitney(a, b *histogram) (p float64, greater float64) {
+	na, nb := float64(a.count()), float64(b.count())
+
+	// Sum the ranks of a in the merged samples, giving ties their average
+	// rank
+	var rankSum float64
+	var rank int64 = 1
+	for i := range a.buckets {
+		inA, inB := a.buckets[i], b.buckets[i]
+		tied := inA + inB
+		if tied == 0 {
+			continue
+		}
+		rankSum += float64(inA) * (float64(rank) + float64(tied-1)/2)
+		rank += tied
+	}
+
+	u := rankSum - na*(na+1)/2 // the number of pairs in which a is greater
+	greater = 1 - u/(na*nb)
+	sd := math.Sqrt(na * nb * (na + nb + 1) / 12)
+	if sd == 0 {
+		return 1, greater
+	}
+	z := (u - na*nb/2) / sd
+	return math.Erfc(math.Abs(z) / math.Sqrt2), greater
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xfxdev/xlog"
+)
+
+// sseRetryDelay is how long to wait before reconnecting a stream which could
+// not be opened, and the least time between reconnections of a stream which
+// the server closes
+const sseRetryDelay = time.Second
+
+// sseMaxLine is the longest line of an event stream that can be read
+const sseMaxLine = 1024 * 1024
+
+var sseConnections int
+
+var sseCmd = &cobra.Command{
+	Use:   "sse [flags] [tag=]URL...",
+	Short: "Load test Server-Sent Events streams",
+	Long: `Load test Server-Sent Events streams.
+
+--connections text/event-stream connections are opened to the URLs in turn,
+and held open until the load test finishes. A stream which is closed, or which
+can't be opened, is reconnected. The events received on each stream are
+counted, and the summary shows how long streams lasted, how long the first
+event took to arrive and the gaps between events.
+
+The duration, header, TLS and connection flags apply as they do to a normal
+load test, and --timeout-seconds limits how long to wait for a stream's
+response headers. The request rate does not apply.`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger, cfg, err := setup(cmd, args)
+		if err != nil {
+			return err
+		}
+		if sseConnections <= 0 {
+			return errors.New("--connections must be positive")
+		}
+		if cfg.clientPerWorker {
+			return errors.New("--client-per-worker doesn't apply to sse, which has no workers")
+		}
+		if cfg.drain > 0 {
+			return errors.New("--drain doesn't apply to sse, whose streams are held open until the end")
+		}
+		return runSSE(cmd.Context(), logger, cfg, sseConnections)
+	},
+}
+
+// sseStats holds the statistics of the streams of an SSE load test. It is
+// safe for concurrent use.
+type sseStats struct {
+	mu          sync.Mutex
+	open        int
+	opened      int
+	failed      int
+	closed      int // by the server
+	events      int
+	ended       int // streams which have ended
+	minEvents   int // received on a stream which has ended
+	maxEvents   int
+	endedEvents int // received on the streams which have ended
+	lifetimes   histogram
+	firstEvents histogram // from sending the request
+	gaps        histogram // between events on the same stream
+}
+
+// runSSE holds n streams open to the targets in cfg until the load test
+// finishes, then logs the summary
+func runSSE(ctx context.Context, logger *xlog.Logger, cfg config, n int) error {
+	cfg, err := runSetup(ctx, logger, cfg)
+	if err != nil {
+		return err
+	}
+	lt, err := newLoadTest(logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Streams last as long as the load test, so only the response headers
+	// are bounded by the timeout
+	lt.client.Timeout = 0
+	if cfg.headerTimeout == 0 {
+		baseTransport(lt.client).ResponseHeaderTimeout = cfg.timeout
+	}
+	defer lt.client.CloseIdleConnections()
+
+	lt.start = time.Now()
+	var cancel context.CancelFunc
+	if cfg.duration > 0 {
+		lt.ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+	} else {
+		lt.ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	lt.reqCtx = lt.ctx // streams aren't drained
+
+	logger.Infof("Holding %d event streams open", n)
+	s := &sseStats{}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lt.ctx.Err() == nil {
+				lt.stream(s, lt.targets[lt.nextTarget.next()])
+			}
+		}()
+	}
+	if cfg.reportInterval > 0 {
+		go s.run(logger, cfg.reportInterval, lt.ctx.Done())
+	}
+
+	<-lt.ctx.Done()
+	if ctx.Err() != nil {
+		logger.Infof("Interrupted, stopping")
+	}
+	wg.Wait()
+
+	logger = summaryLogger(logger, cfg)
+	logger.Infof("Summary after %v:", time.Since(lt.start).Round(time.Millisecond))
+	logger.Infof("Generated by %s", buildInfo())
+	s.summary(logger, time.Since(lt.start))
+	return nil
+}
+
+// stream opens a single stream to t and reads events from it until it is
+// closed or the load test finishes. If the stream can't be opened, it waits
+// for sseRetryDelay before returning, as it does if the server closed the
+// stream sooner than that after opening it, so that a server which rejects
+// streams isn't flooded with reconnections.
+func (lt *loadTest) stream(s *sseStats, t target) {
+	start := time.Now()
+	req := lt.newRequest(lt.ctx, t)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	err := lt.sign(req)
+	var resp *http.Response
+	if err == nil {
+		resp, err = lt.client.Do(req)
+	}
+	if err == nil {
+		err = checkResponse(resp, lt.cfg.okCodes, lt.cfg.expectHeaders)
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && mediaType != "text/event-stream" {
+			err = fmt.Errorf("unexpected Content-Type %q", resp.Header.Get("Content-Type"))
+		}
+		if err != nil {
+			resp.Body.Close()
+		}
+	}
+	if err != nil {
+		if lt.ctx.Err() != nil {
+			return
+		}
+		lt.logger.Debugf("Unable to open stream to %s: %s", t.url, err)
+		s.record(func() { s.failed++ })
+		lt.sseWait(sseRetryDelay)
+		return
+	}
+	defer resp.Body.Close()
+	connected := time.Now()
+	s.record(func() { s.opened++; s.open++ })
+
+	// An event is dispatched by a blank line following any of its fields
+	events, last, pending := 0, time.Time{}, false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, sseMaxLine)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			pending = pending || !strings.HasPrefix(line, ":")
+			continue
+		}
+		if !pending {
+			continue
+		}
+		pending = false
+
+		now := time.Now()
+		events++
+		s.record(func() {
+			s.events++
+			if last.IsZero() {
+				s.firstEvents.record(now.Sub(start))
+			} else {
+				s.gaps.record(now.Sub(last))
+			}
+		})
+		last = now
+	}
+
+	byServer := lt.ctx.Err() == nil
+	if err := scanner.Err(); err != nil && byServer {
+		lt.logger.Debugf("Stream to %s failed: %s", t.url, err)
+	}
+	s.record(func() {
+		s.open--
+		if byServer {
+			s.closed++
+		}
+		if s.ended == 0 || events < s.minEvents {
+			s.minEvents = events
+		}
+		if events > s.maxEvents {
+			s.maxEvents = events
+		}
+		s.ended++
+		s.endedEvents += events
+		s.lifetimes.record(time.Since(connected))
+	})
+	if byServer {
+		lt.sseWait(sseRetryDelay - time.Since(connected))
+	}
+}
+
+// sseWait waits for d, or until the load test finishes
+func (lt *loadTest) sseWait(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-lt.ctx.Done():
+	}
+}
+
+// record calls update with s.mu held
+func (s *sseStats) record(update func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update()
+}
+
+// run logs the number of open streams and the events received every
+// interval until stop is closed
+func (s *sseStats) run(logger *xlog.Logger, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last, lastTime := 0, time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			open, events, failed, closed := s.open, s.events, s.failed, s.closed
+			s.mu.Unlock()
+			rate := float64(events-last) / now.Sub(lastTime).Seconds()
+			last, lastTime = events, now
+			logger.Infof("%d streams open, %d events received (%.1f events/s), %d failed to open, %d closed by the server", open, events, rate, failed, closed)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// summary logs the statistics of the streams of a load test which ran for
+// elapsed
+func (s *sseStats) summary(logger *xlog.Logger, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logger.Infof("Opened %d streams, %d failed to open, %d closed by the server", s.opened, s.failed, s.closed)
+	logger.Infof("Received %d events (%.1f events/s)", s.events, float64(s.events)/elapsed.Seconds())
+	if s.ended > 0 {
+		logger.Infof("Events per stream: min %d, mean %.1f, max %d", s.minEvents, float64(s.endedEvents)/float64(s.ended), s.maxEvents)
+	}
+	logQuantiles(logger, "lifetime   ", s.lifetimes.snapshot())
+	logQuantiles(logger, "first event", s.firstEvents.snapshot())
+	logQuantiles(logger, "event gap  ", s.gaps.snapshot())
+}
+
+func init() {
+	sseCmd.Flags().IntVar(&sseConnections, "connections", 10, "number of event streams to hold open")
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/xfxdev/xlog"
+)
+
+// phase is a stage of a request whose latency is measured
+type phase int
+
+const (
+	phaseDNS      phase = iota // resolving the host name
+	phaseConnect               // establishing the TCP connection
+	phaseTLS                   // performing the TLS handshake
+	phaseTTFB                  // from the request being written until the first response byte
+	phaseBody                  // from the first response byte until the body is closed
+	phaseTotal                 // the whole request
+	phaseIntended              // from when the request should have been sent until the body is closed
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"dns", "connect", "tls", "ttfb", "body", "total", "intended"}
+
+// timings holds how long each phase of a request took. A phase that did not
+// occur, such as dns and connect on a re-used connection, is zero.
+type timings [numPhases]time.Duration
+
+// stats holds the statistics for a load test. It is safe for concurrent use,
+// and recording doesn't lock: the counts are updated atomically, and the
+// latencies are counted in histograms, so that the memory used doesn't grow
+// however long the load test runs.
+type stats struct {
+	ok        int64                     // accessed atomically
+	failed    int64                     // accessed atomically
+	redirects int64                     // accessed atomically
+	bytes     int64                     // accessed atomically
+	expected  int64                     // accessed atomically
+	newConns  int64                     // accessed atomically
+	condition int64                     // accessed atomically
+	unchanged int64                     // accessed atomically
+	timeouts  [numStages]int64          // by stage, accessed atomically
+	apdex     [zoneFrustrated + 1]int64 // by zone, accessed atomically
+	latencies [numPhases]histogram
+}
+
+// taggedStats holds the overall statistics for a load test, along with the
+// statistics for each tag and each segment of the load test, such as spikes
+type taggedStats struct {
+	all       stats
+	tags      []string
+	byTag     map[string]*stats
+	segments  []string
+	bySegment map[string]*stats
+}
+
+// newTaggedStats returns stats for the given tags and segments. Duplicate
+// tags share their statistics.
+func newTaggedStats(tags, segments []string) *taggedStats {
+	ts := &taggedStats{byTag: map[string]*stats{}, segments: segments, bySegment: map[string]*stats{}}
+	for _, tag := range tags {
+		if _, ok := ts.byTag[tag]; ok {
+			continue
+		}
+		ts.tags = append(ts.tags, tag)
+		ts.byTag[tag] = &stats{}
+	}
+	for _, segment := range segments {
+		ts.bySegment[segment] = &stats{}
+	}
+	return ts
+}
+
+// record adds the result of a single request to the overall stats and the
+// stats for its tag and segment
+func (ts *taggedStats) record(r result) {
+	ts.all.record(r)
+	ts.byTag[r.tag].record(r)
+	if s, ok := ts.bySegment[r.segment]; ok {
+		s.record(r)
+	}
+}
+
+// record adds the result of a single request to the stats
+func (s *stats) record(r result) {
+	for p, d := range r.timings {
+		if d > 0 {
+			s.latencies[p].record(d)
+		}
+	}
+
+	// The counts are updated last, so that once a result is counted its
+	// latencies can be read
+	atomic.AddInt64(&s.redirects, int64(r.redirects))
+	atomic.AddInt64(&s.bytes, r.bytes)
+	if r.expected {
+		atomic.AddInt64(&s.expected, 1)
+	}
+	if r.newConn {
+		atomic.AddInt64(&s.newConns, 1)
+	}
+	if r.condition {
+		atomic.AddInt64(&s.condition, 1)
+	}
+	if r.unchanged {
+		atomic.AddInt64(&s.unchanged, 1)
+	}
+	if r.timedOut != stageNone {
+		atomic.AddInt64(&s.timeouts[r.timedOut], 1)
+	}
+	atomic.AddInt64(&s.apdex[r.apdex], 1)
+	if r.ok {
+		atomic.AddInt64(&s.ok, 1)
+	} else {
+		atomic.AddInt64(&s.failed, 1)
+	}
+}
+
+// counts is a snapshot of the request counts in stats
+type counts struct {
+	ok        int
+	failed    int
+	redirects int
+	bytes     int64          // of response bodies read
+	expected  int            // ok requests which got one of the --expect-codes
+	newConns  int            // requests which opened a new connection
+	timeouts  [numStages]int // requests which timed out, by stage
+
+	// conditional requests, and those which got 304 Not Modified, if
+	// --revalidate is set
+	conditional int
+	notModified int
+
+	// requests in each Apdex zone, if --apdex-t is set
+	satisfied  int
+	tolerating int
+	frustrated int
+}
+
+// sent returns the total number of requests sent
+func (c counts) sent() int {
+	return c.ok + c.failed
+}
+
+// sub returns the counts since the earlier snapshot prev
+func (c counts) sub(prev counts) counts {
+	var timeouts [numStages]int
+	for s := range timeouts {
+		timeouts[s] = c.timeouts[s] - prev.timeouts[s]
+	}
+	return counts{
+		ok:        c.ok - prev.ok,
+		failed:    c.failed - prev.failed,
+		redirects: c.redirects - prev.redirects,
+		bytes:     c.bytes - prev.bytes,
+		expected:  c.expected - prev.expected,
+		newConns:  c.newConns - prev.newConns,
+		timeouts:  timeouts,
+
+		conditional: c.conditional - prev.conditional,
+		notModified: c.notModified - prev.notModified,
+
+		satisfied:  c.satisfied - prev.satisfied,
+		tolerating: c.tolerating - prev.tolerating,
+		frustrated: c.frustrated - prev.frustrated,
+	}
+}
+
+// counts returns a snapshot of the request counts
+func (s *stats) counts() counts {
+	var timeouts [numStages]int
+	for i := range timeouts {
+		timeouts[i] = int(atomic.LoadInt64(&s.timeouts[i]))
+	}
+	return counts{
+		ok:        int(atomic.LoadInt64(&s.ok)),
+		failed:    int(atomic.LoadInt64(&s.failed)),
+		redirects: int(atomic.LoadInt64(&s.redirects)),
+		bytes:     atomic.LoadInt64(&s.bytes),
+		expected:  int(atomic.LoadInt64(&s.expected)),
+		newConns:  int(atomic.LoadInt64(&s.newConns)),
+		timeouts:  timeouts,
+
+		conditional: int(atomic.LoadInt64(&s.condition)),
+		notModified: int(atomic.LoadInt64(&s.unchanged)),
+
+		satisfied:  int(atomic.LoadInt64(&s.apdex[zoneSatisfied])),
+		tolerating: int(atomic.LoadInt64(&s.apdex[zoneTolerating])),
+		frustrated: int(atomic.LoadInt64(&s.apdex[zoneFrustrated])),
+	}
+}
+
+// statsSnapshot is a snapshot of the counts and total latencies in stats, so
+// that the statistics of the requests recorded between two snapshots can be
+// found without locking while recording
+type statsSnapshot struct {
+	counts    counts
+	latencies *histogram
+}
+
+// snapshot returns a snapshot of the counts and total latencies
+func (s *stats) snapshot() statsSnapshot {
+	// The counts are read first, as they are updated last, so that every
+	// request counted is also in the latencies
+	c := s.counts()
+	return statsSnapshot{counts: c, latencies: s.latencyHistogram(phaseTotal)}
+}
+
+// sub returns the statistics of the requests recorded since prev, an earlier
+// snapshot of the same stats, or since they were created if prev is zero
+func (s statsSnapshot) sub(prev statsSnapshot) statsSnapshot {
+	if prev.latencies == nil {
+		return s
+	}
+	return statsSnapshot{counts: s.counts.sub(prev.counts), latencies: s.latencies.sub(prev.latencies)}
+}
+
+// latencyHistogram returns a snapshot of the latencies recorded for phase p
+func (s *stats) latencyHistogram(p phase) *histogram {
+	return s.latencies[p].snapshot()
+}
+
+// logLatencies logs the latency percentiles of the given phases, or of all
+// phases if none are given, with each line starting with prefix. Phases which
+// have not occurred are skipped.
+func (s *stats) logLatencies(logger *xlog.Logger, prefix string, phases ...phase) {
+	if len(phases) == 0 {
+		for p := phase(0); p < numPhases; p++ {
+			phases = append(phases, p)
+		}
+	}
+
+	for _, p := range phases {
+		logQuantiles(logger, fmt.Sprintf("%s%-8s", prefix, phaseNames[p]), s.latencyHistogram(p))
+	}
+}
+
+// logQuantiles logs the percentiles of q on a line starting with name, unless
+// q is empty
+func logQuantiles(logger *xlog.Logger, name string, q quantiles) {
+	if q.count() == 0 {
+		return
+	}
+	logger.Infof("%s p50 %v, p90 %v, p99 %v, max %v (%d samples)", name,
+		q.percentile(50).Round(time.Microsecond),
+		q.percentile(90).Round(time.Microsecond),
+		q.percentile(99).Round(time.Microsecond),
+		q.percentile(100).Round(time.Microsecond),
+		q.count())
+}
+
+// latencyMillis returns the p50, p90, p99 and max of q in milliseconds, by
+// name, or nil if there are none
+func latencyMillis(q quantiles) map[string]float64 {
+	if q.count() == 0 {
+		return nil
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return map[string]float64{
+		"p50": ms(q.percentile(50)),
+		"p90": ms(q.percentile(90)),
+		"p99": ms(q.percentile(99)),
+		"max": ms(q.percentile(100)),
+	}
+}
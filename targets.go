@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// target is an endpoint to send requests to during a load test
+type target struct {
+	tag     string
+	method  string
+	url     string
+	headers http.Header
+	body    []byte
+	weight  int           // relative share of the requests, if the targets are weighted
+	params  []randomParam // given new values in the URL's {{NAME}} placeholders in each request
+	req     *http.Request
+}
+
+// tagPattern matches the optional "tag=" prefix of a target
+var tagPattern = regexp.MustCompile(`^([A-Za-z0-9_.-]+)=`)
+
+// parseTarget parses a target of the form "[tag=]URL". If no tag is given,
+// the URL is used as the tag.
+func parseTarget(s string) (target, error) {
+	t := target{tag: s, url: s}
+	if m := tagPattern.FindStringSubmatch(s); m != nil {
+		t.tag, t.url = m[1], s[len(m[0]):]
+	}
+
+	if _, err := url.Parse(t.url); err != nil {
+		return target{}, fmt.Errorf("unable to parse %q to a valid URL", t.url)
+	}
+	return t, nil
+}
+
+// readTargetsFile reads targets from the file at path, or from stdin if path
+// is "-". See readTargets for the format.
+func readTargetsFile(path string) ([]target, error) {
+	if path == "-" {
+		return readTargets(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTargets(f)
+}
+
+// readTargets reads targets from r. Each target starts with a line of the
+// form "METHOD [tag=]URL [weight=N]", optionally followed by "Name: value"
+// header lines and a line of the form "@path" naming a file to send as the
+// body. Targets may be separated by blank lines, and lines starting with #
+// are ignored. If any target has a weight, the requests are divided between
+// them in proportion, with targets that have none given a weight of 1.
+//
+//	GET reads=https://example.com/items weight=9
+//
+//	POST create=https://example.com/items
+//	Content-Type: application/json
+//	@item.json
+func readTargets(r io.Reader) ([]target, error) {
+	var targets []target
+	var current *target
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+
+		case strings.HasPrefix(line, "@"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: body given before any target", n)
+			}
+			body, err := os.ReadFile(line[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			current.body = body
+
+		case isTargetLine(line):
+			fields := strings.Fields(line)
+			if len(fields) != 2 && len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: expected \"METHOD URL\" or \"METHOD URL weight=N\"", n)
+			}
+			t, err := parseTarget(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if len(fields) == 3 {
+				if !strings.HasPrefix(fields[2], "weight=") {
+					return nil, fmt.Errorf("line %d: expected weight=N after the URL, not %q", n, fields[2])
+				}
+				if t.weight, err = parseWeight(strings.TrimPrefix(fields[2], "weight=")); err != nil {
+					return nil, fmt.Errorf("line %d: %w", n, err)
+				}
+			}
+			t.method = strings.ToUpper(fields[0])
+			t.headers = http.Header{}
+			targets = append(targets, t)
+			current = &targets[len(targets)-1]
+
+		default:
+			if current == nil {
+				return nil, fmt.Errorf("line %d: header given before any target", n)
+			}
+			i := strings.Index(line, ":")
+			if i <= 0 {
+				return nil, fmt.Errorf("line %d: expected \"Name: value\" header", n)
+			}
+			current.headers.Add(textproto.TrimString(line[:i]), textproto.TrimString(line[i+1:]))
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// parseWeight parses the weight of a target, which must be a positive integer
+func parseWeight(s string) (int, error) {
+	w, err := strconv.Atoi(s)
+	if err != nil || w <= 0 {
+		return 0, fmt.Errorf("weight %q must be a positive integer", s)
+	}
+	return w, nil
+}
+
+// weighTargets gives every unweighted target a weight of 1 if any target is
+// weighted, so that all of them are sent requests
+func weighTargets(targets []target) {
+	weighted := false
+	for _, t := range targets {
+		weighted = weighted || t.weight > 0
+	}
+	for i := range targets {
+		if weighted && targets[i].weight == 0 {
+			targets[i].weight = 1
+		}
+	}
+}
+
+// isTargetLine returns true if line starts a new target, i.e. it starts with
+// an HTTP method followed by a space
+func isTargetLine(line string) bool {
+	i := strings.IndexAny(line, " \t")
+	if i <= 0 {
+		return false
+	}
+	method := line[:i]
+	return strings.ToUpper(method) == method && !strings.Contains(method, ":")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// thinkTime is how long a worker pauses after each request, to simulate a
+// person between steps. The pause is either fixed at min, or chosen
+// uniformly between min and max.
+type thinkTime struct {
+	min time.Duration
+	max time.Duration
+}
+
+// parseThinkTime parses a think time of the form "fixed 500ms" or
+// "uniform 100ms-800ms". A duration on its own is fixed.
+func parseThinkTime(s string) (thinkTime, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 1 {
+		fields = []string{"fixed", fields[0]}
+	}
+	if len(fields) != 2 {
+		return thinkTime{}, fmt.Errorf("invalid think time %q: expected \"fixed 500ms\" or \"uniform 100ms-800ms\"", s)
+	}
+
+	switch fields[0] {
+	case "fixed":
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d < 0 {
+			return thinkTime{}, fmt.Errorf("invalid think time %q: %q is not a duration", s, fields[1])
+		}
+		return thinkTime{min: d, max: d}, nil
+	case "uniform":
+		bounds := strings.SplitN(fields[1], "-", 2)
+		if len(bounds) != 2 {
+			return thinkTime{}, fmt.Errorf("invalid think time %q: expected a range such as 100ms-800ms", s)
+		}
+		// A unit on only the upper bound applies to both, as in "100-800ms"
+		if _, err := time.ParseDuration(bounds[0]); err != nil {
+			bounds[0] += strings.TrimLeft(bounds[1], "0123456789.")
+		}
+		min, err := time.ParseDuration(bounds[0])
+		if err != nil || min < 0 {
+			return thinkTime{}, fmt.Errorf("invalid think time %q: %q is not a duration", s, bounds[0])
+		}
+		max, err := time.ParseDuration(bounds[1])
+		if err != nil || max < min {
+			return thinkTime{}, fmt.Errorf("invalid think time %q: %q is not a duration of at least %v", s, bounds[1], min)
+		}
+		return thinkTime{min: min, max: max}, nil
+	default:
+		return thinkTime{}, fmt.Errorf("invalid think time %q: distribution must be fixed or uniform", s)
+	}
+}
+
+// next returns the length of the next pause
+func (t thinkTime) next() time.Duration {
+	if t.max <= t.min {
+		return t.min
+	}
+	return t.min + time.Duration(rand.Int63n(int64(t.max-t.min)+1))
+}
+
+// mean returns the average length of a pause
+func (t thinkTime) mean() time.Duration {
+	return (t.min + t.max) / 2
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunksPerSecond is how many chunks each second of a throttled
+// connection's bandwidth is read or written in, so that data flows steadily
+// rather than in bursts
+const throttleChunksPerSecond = 20
+
+// bandwidthUnits are the units of a bandwidth, in bits per second, with the
+// longest suffixes first
+var bandwidthUnits = []struct {
+	suffix string
+	bits   float64
+}{
+	{"gbps", 1e9},
+	{"mbps", 1e6},
+	{"kbps", 1e3},
+	{"bps", 1},
+}
+
+// bandwidth is a rate of data in bytes per second
+type bandwidth int64
+
+// parseBandwidth parses a bandwidth in bits per second, such as "1mbps" or
+// "56kbps"
+func parseBandwidth(s string) (bandwidth, error) {
+	lower := strings.ToLower(s)
+	for _, u := range bandwidthUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSpace(lower[:len(lower)-len(u.suffix)]), 64)
+			if err != nil || v <= 0 {
+				break
+			}
+			if b := bandwidth(v * u.bits / 8); b > 0 {
+				return b, nil
+			}
+			return 0, fmt.Errorf("invalid bandwidth %q: must be at least 8bps", s)
+		}
+	}
+	return 0, fmt.Errorf("invalid bandwidth %q: expected a positive number of bits per second, such as 1mbps or 56kbps", s)
+}
+
+// String returns the bandwidth in bits per second, in the largest unit it is
+// at least one of
+func (b bandwidth) String() string {
+	bits := float64(b) * 8
+	for _, u := range bandwidthUnits {
+		if bits >= u.bits || u.bits == 1 {
+			return strconv.FormatFloat(bits/u.bits, 'f', -1, 64) + u.suffix
+		}
+	}
+	return ""
+}
+
+// throttle limits the rate of data in one direction of a connection. It is
+// safe for concurrent use.
+type throttle struct {
+	rate bandwidth
+
+	mu   sync.Mutex
+	next time.Time // when the data passed so far is due to have finished
+}
+
+// chunk returns the most bytes to pass at once
+func (t *throttle) chunk() int {
+	if n := int(t.rate / throttleChunksPerSecond); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// wait waits until n bytes more would have passed at the throttled rate
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / float64(t.rate) * float64(time.Second)))
+	d := t.next.Sub(now)
+	t.mu.Unlock()
+	time.Sleep(d)
+}
+
+// throttledConn is a connection whose reads and writes are each limited to a
+// bandwidth, to simulate a slow client such as a phone on a mobile network.
+// Reading slowly leaves the rest of a response in the server's buffers, so
+// it has to hold the connection open as a slow client would make it.
+type throttledConn struct {
+	net.Conn
+	read  throttle
+	write throttle
+}
+
+// newThrottledConn returns conn limited to rate in each direction
+func newThrottledConn(conn net.Conn, rate bandwidth) net.Conn {
+	return &throttledConn{Conn: conn, read: throttle{rate: rate}, write: throttle{rate: rate}}
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if n := c.read.chunk(); len(p) > n {
+		p = p[:n]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.read.wait(n)
+	}
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if n := c.write.chunk(); len(chunk) > n {
+			chunk = chunk[:n]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		c.write.wait(n)
+		p = p[n:]
+	}
+	return written, nil
+}
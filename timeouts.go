@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/xfxdev/xlog"
+)
+
+// stage is the part of a request in progress when it timed out
+type stage int
+
+const (
+	stageNone    stage = iota // the request didn't time out
+	stageDNS                  // resolving the host name
+	stageConnect              // establishing the TCP connection
+	stageTLS                  // performing the TLS handshake
+	stageRequest              // waiting for a connection, or writing the request
+	stageHeaders              // waiting for the response headers
+	stageBody                 // reading the response body
+	numStages
+)
+
+var stageNames = [numStages]string{"", "dns", "connect", "tls", "request", "response headers", "body"}
+
+// isTimeout returns true if err is from a request which ran out of time,
+// whether from --total-timeout or one of the limits on a single stage
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// timeoutError returns the error of a request which timed out in stage s
+func timeoutError(s stage, err error) error {
+	return fmt.Errorf("timed out during %s: %w", stageNames[s], err)
+}
+
+// logTimeouts logs how many requests timed out in each stage, if any did, to
+// tell connections which stall apart from slow responses
+func (lt *loadTest) logTimeouts(logger *xlog.Logger) {
+	c := lt.stats.all.counts()
+	var stages []string
+	total := 0
+	for s := stageNone + 1; s < numStages; s++ {
+		if n := c.timeouts[s]; n > 0 {
+			stages = append(stages, fmt.Sprintf("%d during %s", n, stageNames[s]))
+			total += n
+		}
+	}
+	if total > 0 {
+		logger.Warnf("Timeouts: %d requests timed out, %s", total, strings.Join(stages, ", "))
+	}
+}
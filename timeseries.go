@@ -0,0 +1,218 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxSeriesSeconds is the most seconds kept in a time series, a day, beyond
+// which the oldest are dropped so that the memory used is bounded
+const maxSeriesSeconds = 24 * 60 * 60
+
+// summaryPercentiles are the percentiles kept for each second of a time
+// series, which are those shown in reports
+var summaryPercentiles = [...]float64{50, 90, 99, 100}
+
+// timeSeries holds a summary of the requests which completed in each second
+// of a load test, so that reports can show when things changed, and a sample
+// of the requests for plotting. Requests are recorded in lock-free stats,
+// and each second is summarised from the difference between snapshots of
+// them, taken by the first request to complete in the next second. The
+// summaries are kept in a ring of the latest maxSeriesSeconds, so the memory
+// used is bounded however high the rate or long the load test. It is safe
+// for concurrent use, and only locks to summarise a second or keep a sampled
+// request.
+type timeSeries struct {
+	total    stats // of every request
+	closed   int64 // number of seconds summarised so far, accessed atomically
+	slow     int64 // the p99 of the last complete second, accessed atomically
+	noPoints int32 // non-zero once points are no longer sampled, accessed atomically
+
+	mu      sync.Mutex
+	seconds []second      // the ring of summaries
+	last    statsSnapshot // of total, when the last second was summarised
+	notable reservoir     // failed or slow requests
+	rest    reservoir
+}
+
+// second summarises the requests which completed in one second of a load
+// test
+type second struct {
+	counts    counts
+	latencies latencySummary
+}
+
+// latencySummary holds the summaryPercentiles of a set of latencies in place
+// of the latencies themselves
+type latencySummary struct {
+	n           int
+	percentiles [len(summaryPercentiles)]time.Duration
+}
+
+// summarise returns the summary of the latencies in the snapshot h
+func summarise(h *histogram) latencySummary {
+	s := latencySummary{n: h.count()}
+	if s.n > 0 {
+		for i, p := range summaryPercentiles {
+			s.percentiles[i] = h.percentile(p)
+		}
+	}
+	return s
+}
+
+func (s latencySummary) count() int {
+	return s.n
+}
+
+// percentile returns the smallest of the kept percentiles which is at least
+// p, which is exact for any of the summaryPercentiles
+func (s latencySummary) percentile(p float64) time.Duration {
+	for i, kept := range summaryPercentiles {
+		if p <= kept {
+			return s.percentiles[i]
+		}
+	}
+	return s.percentiles[len(s.percentiles)-1]
+}
+
+// latencyPoint is a single request in a time series, for plotting
+type latencyPoint struct {
+	start   time.Duration // since the load test started
+	latency time.Duration
+	ok      bool
+}
+
+// reservoir is a uniform random sample of up to maxPlotPoints/2 points,
+// however many are offered
+type reservoir struct {
+	seen   int64 // accessed atomically
+	points []latencyPoint
+}
+
+// offer offers p to the sample, returning the index to store it at, or -1
+// if it isn't kept. The random index is a hash of the number of points seen
+// and p, so that most points can be dropped without locking.
+func (r *reservoir) offer(p latencyPoint) int {
+	n := atomic.AddInt64(&r.seen, 1)
+	if n <= maxPlotPoints/2 {
+		return int(n - 1)
+	}
+	if i := int(mix64(uint64(n)^uint64(p.start)) % uint64(n)); i < maxPlotPoints/2 {
+		return i
+	}
+	return -1
+}
+
+// keep stores p at index i, as returned by offer, or adds it if the sample
+// isn't full, as points offered concurrently may be kept out of order. The
+// caller must hold the series' lock.
+func (r *reservoir) keep(i int, p latencyPoint) {
+	if i < len(r.points) {
+		r.points[i] = p
+	} else if len(r.points) < maxPlotPoints/2 {
+		r.points = append(r.points, p)
+	}
+}
+
+// mix64 returns a well mixed hash of x, using the splitmix64 finaliser
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// record adds the result of a request which completed at elapsed after the
+// load test started. A result which arrives after a later one has started the
+// next second is counted in that second.
+func (ts *timeSeries) record(elapsed time.Duration, r result) {
+	if i := int64(elapsed / time.Second); atomic.LoadInt64(&ts.closed) < i {
+		ts.summarise(i)
+	}
+	ts.total.record(r)
+
+	if atomic.LoadInt32(&ts.noPoints) != 0 {
+		return
+	}
+	latency := r.timings[phaseTotal]
+	p := latencyPoint{start: elapsed - latency, latency: latency, ok: r.ok}
+	res := &ts.rest
+	if slow := time.Duration(atomic.LoadInt64(&ts.slow)); !r.ok || (slow > 0 && latency >= slow) {
+		res = &ts.notable
+	}
+	if i := res.offer(p); i >= 0 {
+		ts.mu.Lock()
+		if atomic.LoadInt32(&ts.noPoints) == 0 {
+			res.keep(i, p)
+		}
+		ts.mu.Unlock()
+	}
+}
+
+// summarise summarises each second before second i which hasn't been yet.
+// Any but the first are empty, as no requests completed in them.
+func (ts *timeSeries) summarise(i int64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for closed := atomic.LoadInt64(&ts.closed); closed < i; closed++ {
+		now := ts.total.snapshot()
+		d := now.sub(ts.last)
+		ts.last = now
+		s := second{counts: d.counts, latencies: summarise(d.latencies)}
+		if len(ts.seconds) < maxSeriesSeconds {
+			ts.seconds = append(ts.seconds, s)
+		} else {
+			ts.seconds[closed%maxSeriesSeconds] = s
+		}
+		if s.latencies.count() > 0 {
+			atomic.StoreInt64(&ts.slow, int64(s.latencies.percentile(99)))
+		}
+		atomic.StoreInt64(&ts.closed, closed+1)
+	}
+}
+
+// buckets returns a summary of the requests in each second kept so far,
+// including the current one, and the second of the load test the first is
+// for
+func (ts *timeSeries) buckets() ([]second, int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	closed := int(atomic.LoadInt64(&ts.closed))
+	var oldest int
+	if len(ts.seconds) == maxSeriesSeconds {
+		oldest = closed % maxSeriesSeconds
+	}
+	buckets := append(append([]second(nil), ts.seconds[oldest:]...), ts.seconds[:oldest]...)
+	first := closed - len(ts.seconds)
+	current := ts.total.snapshot().sub(ts.last)
+	if current.counts.sent() == 0 {
+		return buckets, first
+	}
+	return append(buckets, second{counts: current.counts, latencies: summarise(current.latencies)}), first
+}
+
+// latencies returns the sampled requests, in the order they were sent, and
+// the number of requests they were sampled from
+func (ts *timeSeries) latencies() ([]latencyPoint, int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	points := append(append([]latencyPoint(nil), ts.notable.points...), ts.rest.points...)
+	sort.Slice(points, func(i, j int) bool { return points[i].start < points[j].start })
+	return points, int(atomic.LoadInt64(&ts.notable.seen) + atomic.LoadInt64(&ts.rest.seen))
+}
+
+// stopSampling stops sampling requests for plotting, freeing those already
+// sampled
+func (ts *timeSeries) stopSampling() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	atomic.StoreInt32(&ts.noPoints, 1)
+	ts.notable.points, ts.rest.points = nil, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions are the TLS versions that may be given to --tls-min-version and
+// --tls-max-version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS version such as "1.2", returning 0 for an
+// empty string
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %q: must be one of 1.0, 1.1, 1.2 or 1.3", s)
+	}
+	return v, nil
+}
+
+// parseCipherSuites parses the names of cipher suites, such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", including insecure suites so that
+// their rejection can be tested
+func parseCipherSuites(names []string) ([]uint16, error) {
+	ids := map[string]uint16{}
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		ids[s.Name] = s.ID
+	}
+
+	var suites []uint16
+	for _, name := range names {
+		id, ok := ids[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// newTLSConfig returns the TLS config for requests, or nil to use the
+// defaults
+func newTLSConfig(cfg config) *tls.Config {
+	if cfg.tlsMinVersion == 0 && cfg.tlsMaxVersion == 0 && len(cfg.tlsCiphers) == 0 && cfg.sni == "" {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:   cfg.tlsMinVersion,
+		MaxVersion:   cfg.tlsMaxVersion,
+		CipherSuites: cfg.tlsCiphers,
+		ServerName:   cfg.sni,
+	}
+}
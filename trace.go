@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// tracer records the phase timings of a single request. The callbacks may be
+// called from the transport's dialing goroutines, so access is guarded by mu.
+type tracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	timings      timings
+	newConn      bool  // true if a new connection was opened, rather than an idle one re-used
+	failed       stage // the last stage of connecting which failed, if any
+}
+
+// newTracer returns a tracer for a request starting now
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// clientTrace returns the hooks to attach to the request's context. Phases
+// are summed so that requests which follow redirects report the total time
+// spent in each phase.
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mark(&t.dnsStart)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			t.done(phaseDNS, &t.dnsStart, stageDNS, info.Err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				t.mu.Lock()
+				t.newConn = true
+				t.mu.Unlock()
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			t.mark(&t.connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.done(phaseConnect, &t.connectStart, stageConnect, err)
+		},
+		TLSHandshakeStart: func() {
+			t.mark(&t.tlsStart)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.done(phaseTLS, &t.tlsStart, stageTLS, err)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mark(&t.wroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			t.mark(&t.firstByte)
+			t.add(phaseTTFB, &t.wroteRequest)
+		},
+	}
+}
+
+// mark sets ts to the current time
+func (t *tracer) mark(ts *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*ts = time.Now()
+}
+
+// add adds the time since start to phase p, and clears start as the phase
+// has finished
+func (t *tracer) add(p phase, start *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !start.IsZero() {
+		t.timings[p] += time.Since(*start)
+		*start = time.Time{}
+	}
+}
+
+// done adds the time since start to phase p, which is part of stage s of
+// connecting, recording the stage as failed if err is not nil
+func (t *tracer) done(p phase, start *time.Time, s stage, err error) {
+	if err != nil {
+		t.mu.Lock()
+		t.failed = s
+		t.mu.Unlock()
+	}
+	t.add(p, start)
+}
+
+// stage returns the stage of the request in progress, or the stage of
+// connecting which last failed if the request hasn't been written
+func (t *tracer) stage() stage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case !t.firstByte.IsZero():
+		return stageBody
+	case !t.wroteRequest.IsZero():
+		return stageHeaders
+	case t.failed != stageNone:
+		return t.failed
+	case !t.tlsStart.IsZero():
+		return stageTLS
+	case !t.connectStart.IsZero():
+		return stageConnect
+	case !t.dnsStart.IsZero():
+		return stageDNS
+	default:
+		return stageRequest
+	}
+}
+
+// openedConn returns true if a new connection was opened for the request, or
+// any of its redirects
+func (t *tracer) openedConn() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.newConn
+}
+
+// finish should be called once as much of the response body as is to be
+// timed has been read, and returns the timings of the request
+func (t *tracer) finish() timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if !t.firstByte.IsZero() {
+		t.timings[phaseBody] = now.Sub(t.firstByte)
+	}
+	t.timings[phaseTotal] = now.Sub(t.start)
+	return t.timings
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceBodyLimit is the most of each request and response body written to
+// the trace log
+const traceBodyLimit = 4096
+
+// traceLog writes the full details of a sample of requests to a file as
+// newline delimited JSON, so that individual slow or failed requests can be
+// inspected after the load test. It is safe for concurrent use.
+type traceLog struct {
+	rate float64 // fraction of requests to trace
+
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	closed bool
+}
+
+// traceEntry is a single traced request in the trace log. The values of
+// headers which look like secrets are masked.
+type traceEntry struct {
+	Time                  time.Time          `json:"time"`
+	ID                    string             `json:"id,omitempty"`
+	Tag                   string             `json:"tag"`
+	Method                string             `json:"method"`
+	URL                   string             `json:"url"`
+	RequestHeaders        http.Header        `json:"request_headers"`
+	RequestBody           string             `json:"request_body,omitempty"`
+	RequestBodyTruncated  bool               `json:"request_body_truncated,omitempty"`
+	Status                int                `json:"status,omitempty"`
+	ResponseHeaders       http.Header        `json:"response_headers,omitempty"`
+	ResponseBody          string             `json:"response_body,omitempty"`
+	ResponseBodyTruncated bool               `json:"response_body_truncated,omitempty"`
+	OK                    bool               `json:"ok"`
+	Error                 string             `json:"error,omitempty"`
+	Timings               map[string]float64 `json:"timings_ms"`
+}
+
+// parseTraceSample parses a --trace-sample, either a percentage such as "1%"
+// or a fraction such as "0.01"
+func parseTraceSample(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if strings.HasSuffix(s, "%") {
+		f /= 100
+	}
+	if err != nil || f <= 0 || f > 1 {
+		return 0, fmt.Errorf("invalid --trace-sample %q: must be a percentage such as 1%% or a fraction such as 0.01, up to 100%%", s)
+	}
+	return f, nil
+}
+
+// newTraceLog creates the file at path, to trace rate of the requests
+func newTraceLog(path string, rate float64) (*traceLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &traceLog{rate: rate, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// sample returns true if the next request should be traced
+func (tl *traceLog) sample() bool {
+	return rand.Float64() < tl.rate
+}
+
+// write writes e to the log, unless the log is closed
+func (tl *traceLog) write(e traceEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if !tl.closed {
+		tl.w.Write(append(line, '\n'))
+	}
+}
+
+// close flushes and closes the file, returning the first error writing to it
+func (tl *traceLog) close() error {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.closed = true
+	if err := tl.w.Flush(); err != nil {
+		tl.f.Close()
+		return err
+	}
+	return tl.f.Close()
+}
+
+// traceCapture collects the details of a traced request as it is sent
+type traceCapture struct {
+	start    time.Time
+	req      *http.Request
+	reqBody  *bodyCapture
+	respBody *bodyCapture
+}
+
+// newTraceCapture starts tracing req, which must not have been sent yet
+func newTraceCapture(req *http.Request) *traceCapture {
+	tc := &traceCapture{start: time.Now(), req: req}
+	// An empty body is left alone, as wrapping it would make its length
+	// unknown and change how the request is sent
+	if req.Body != nil && req.Body != http.NoBody {
+		tc.reqBody = &bodyCapture{ReadCloser: req.Body}
+		req.Body = tc.reqBody
+	}
+	return tc
+}
+
+// response starts capturing the body of resp as it is read
+func (tc *traceCapture) response(resp *http.Response) {
+	tc.respBody = &bodyCapture{ReadCloser: resp.Body}
+	resp.Body = tc.respBody
+}
+
+// entry returns the trace entry for the request, which had result r and
+// response resp, or failed with err before a response was received
+func (tc *traceCapture) entry(resp *http.Response, r result, err error) traceEntry {
+	e := traceEntry{
+		Time:           tc.start,
+		ID:             r.id,
+		Tag:            r.tag,
+		Method:         tc.req.Method,
+		URL:            tc.req.URL.String(),
+		RequestHeaders: maskHeaders(tc.req.Header),
+		OK:             r.ok,
+		Timings:        map[string]float64{},
+	}
+	if tc.reqBody != nil {
+		e.RequestBody, e.RequestBodyTruncated = tc.reqBody.captured()
+	}
+	if resp != nil {
+		e.Status = resp.StatusCode
+		e.ResponseHeaders = maskHeaders(resp.Header)
+		e.ResponseBody, e.ResponseBodyTruncated = tc.respBody.captured()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	for p, d := range r.timings {
+		if d > 0 {
+			e.Timings[phaseNames[p]] = float64(d) / float64(time.Millisecond)
+		}
+	}
+	return e
+}
+
+// maskHeaders returns a copy of h with the values of sensitive headers masked
+func maskHeaders(h http.Header) http.Header {
+	masked := h.Clone()
+	for name, values := range masked {
+		if !isSensitiveHeader(name) {
+			continue
+		}
+		for i, v := range values {
+			values[i] = maskValue(v)
+		}
+	}
+	return masked
+}
+
+// bodyCapture keeps the first traceBodyLimit bytes read from a body. The
+// transport may still be reading a request body after the response arrives,
+// so it is safe for concurrent use.
+type bodyCapture struct {
+	io.ReadCloser
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *bodyCapture) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	if room := traceBodyLimit - b.buf.Len(); n > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+	} else {
+		b.buf.Write(p[:n])
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+// captured returns the body read so far, and true if it was truncated
+func (b *bodyCapture) captured() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, set at build time with, for example:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show the version of slt",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("slt %s\n", version)
+		fmt.Printf("commit:     %s\n", commit)
+		fmt.Printf("built:      %s\n", buildDate)
+		fmt.Printf("go version: %s\n", runtime.Version())
+	},
+}
+
+// buildInfo describes the build of slt, so that saved results can be traced
+// back to the build that produced them
+func buildInfo() string {
+	return fmt.Sprintf("slt %s (commit %s, built %s, %s)", version, commit, buildDate, runtime.Version())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"sort"
+	"sync/atomic"
+
+	"github.com/xfxdev/xlog"
+)
+
+// maxLoggedClients is the most worker clients which are each logged in the
+// summary
+const maxLoggedClients = 20
+
+// workerClient is the client of a single worker when --client-per-worker is
+// set, with its own connection pool and cookie jar, so that each worker acts
+// as an independent client
+type workerClient struct {
+	client   *http.Client
+	requests int64 // sent by the worker, accessed atomically
+	conns    int64 // opened by the client, accessed atomically
+}
+
+// newWorkerClient returns a new client for a worker, and keeps it to report
+// on in the summary
+func (lt *loadTest) newWorkerClient() *workerClient {
+	wc := &workerClient{client: newClient(lt.cfg, lt.dns)}
+	wc.client.Jar, _ = cookiejar.New(nil) // which never fails
+
+	t := baseTransport(wc.client)
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil {
+			atomic.AddInt64(&wc.conns, 1)
+		}
+		return conn, err
+	}
+
+	lt.clientsMu.Lock()
+	lt.clients = append(lt.clients, wc)
+	lt.clientsMu.Unlock()
+	return wc
+}
+
+// workerClients returns the clients of the workers so far
+func (lt *loadTest) workerClients() []*workerClient {
+	lt.clientsMu.Lock()
+	defer lt.clientsMu.Unlock()
+	return append([]*workerClient(nil), lt.clients...)
+}
+
+// closeIdleConnections closes the idle connections of the shared client and
+// of every worker's client
+func (lt *loadTest) closeIdleConnections() {
+	lt.client.CloseIdleConnections()
+	for _, wc := range lt.workerClients() {
+		wc.client.CloseIdleConnections()
+	}
+}
+
+// logClients logs how many connections each worker's client opened, with a
+// line for each worker if there aren't too many
+func (lt *loadTest) logClients(logger *xlog.Logger) {
+	clients := lt.workerClients()
+	if len(clients) == 0 {
+		return
+	}
+
+	conns := make([]int, len(clients))
+	total := 0
+	for i, wc := range clients {
+		conns[i] = int(atomic.LoadInt64(&wc.conns))
+		total += conns[i]
+	}
+	sort.Ints(conns)
+	logger.Infof("Clients: %d workers each with their own connection pool and cookie jar opened %d connections, %d to %d per worker (median %d)", len(clients), total, conns[0], conns[len(conns)-1], conns[len(conns)/2])
+	if len(clients) > maxLoggedClients {
+		return
+	}
+	for i, wc := range clients {
+		logger.Infof("Client %d: %d requests, %d connections", i+1, atomic.LoadInt64(&wc.requests), atomic.LoadInt64(&wc.conns))
+	}
+}
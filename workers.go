@@ -0,0 +1,181 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// workerPoolWarningInterval is the minimum time between warnings that
+	// the worker pool is too small
+	workerPoolWarningInterval = 10 * time.Second
+
+	// maxDefaultQueue is the most requests which wait for a worker when
+	// --max-queue isn't set, however high the rate
+	maxDefaultQueue = 10000
+)
+
+// job is a request for a worker to send
+type job struct {
+	segment   string
+	scheduled time.Time // when the request should be sent
+}
+
+// workerPool is a pool of workers which send requests for a load test. A
+// fixed size pool warns when every worker is busy, as the requested rate
+// can't then be sustained. An automatically sized pool instead adds workers,
+// up to enough for requests taking the full timeout at the target rate.
+//
+// Once every worker is busy, requests queue for the next idle worker. The
+// queue is bounded, and requests beyond it are skipped and counted, so that
+// a slow target can't make the load generator pile up requests until it
+// runs out of memory.
+type workerPool struct {
+	lt      *loadTest
+	jobs    chan job // the queue
+	auto    bool
+	queue   int   // most requests waiting for a worker, or 0 for a second's worth
+	idle    int64 // workers waiting for a job, accessed atomically
+	skipped int64 // requests skipped as the queue was full, accessed atomically
+
+	mu          sync.Mutex
+	size        int
+	lastWarning time.Time
+}
+
+// newWorkerPool returns a pool of workers for lt. If workers is zero, the
+// pool is sized automatically for the target rate, or a pattern's peak rate,
+// starting with enough workers for requests taking 100ms and growing to
+// enough for requests taking the full timeout.
+// Each worker is also busy for its think time after each request. At most
+// queue requests wait for a worker, or if queue is zero, as many as are
+// sent in the current second.
+func newWorkerPool(lt *loadTest, workers, queue int) *workerPool {
+	capacity := queue
+	if queue <= 0 {
+		capacity = maxDefaultQueue
+	}
+	p := &workerPool{lt: lt, jobs: make(chan job, capacity), queue: queue}
+	if workers <= 0 {
+		p.auto = true
+		busy := 100*time.Millisecond + lt.cfg.thinkTime.mean()
+		workers = int(float64(atomic.LoadInt64(&lt.rps))*busy.Seconds()) + 1
+	}
+	for i := 0; i < workers; i++ {
+		p.add()
+	}
+	return p
+}
+
+// add starts a new worker. The caller must not hold p.mu.
+func (p *workerPool) add() {
+	p.mu.Lock()
+	p.size++
+	p.mu.Unlock()
+	go p.work()
+}
+
+// work sends a request for each job until the load test stops, pausing for
+// the think time after each one. With --client-per-worker, the requests are
+// sent with the worker's own client.
+func (p *workerPool) work() {
+	var wc *workerClient
+	if p.lt.cfg.clientPerWorker {
+		wc = p.lt.newWorkerClient()
+	}
+	for {
+		atomic.AddInt64(&p.idle, 1)
+		select {
+		case j := <-p.jobs:
+			atomic.AddInt64(&p.idle, -1)
+			p.lt.send(wc, j)
+			p.think()
+		case <-p.lt.ctx.Done():
+			atomic.AddInt64(&p.idle, -1)
+			return
+		}
+	}
+}
+
+// think pauses for the think time, unless the load test stops
+func (p *workerPool) think() {
+	d := p.lt.cfg.thinkTime.next()
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.lt.ctx.Done():
+	}
+}
+
+// submit queues a request for the next idle worker, adding a worker to an
+// automatically sized pool if none are idle. If the queue is full the
+// request is skipped, so submit never blocks.
+func (p *workerPool) submit(j job) {
+	if atomic.LoadInt64(&p.idle) == 0 {
+		p.mu.Lock()
+		if p.auto && p.size < p.max() {
+			p.mu.Unlock()
+			p.add()
+			p.lt.logger.Debugf("All workers are busy, added another")
+		} else {
+			p.warn("All %d workers are busy, so the requested rate can't be sustained; consider increasing --workers", p.size)
+			p.mu.Unlock()
+		}
+	}
+
+	if len(p.jobs) < p.limit() {
+		select {
+		case p.jobs <- j:
+			return
+		default:
+		}
+	}
+	atomic.AddInt64(&p.skipped, 1)
+}
+
+// warn logs a warning that the pool is too small, unless one was logged
+// recently. The caller must hold p.mu.
+func (p *workerPool) warn(format string, args ...interface{}) {
+	if time.Since(p.lastWarning) >= workerPoolWarningInterval {
+		p.lt.logger.Warnf(format, args...)
+		p.lastWarning = time.Now()
+	}
+}
+
+// limit returns the most requests which may wait for a worker
+func (p *workerPool) limit() int {
+	switch n := p.lt.requested.current(); {
+	case p.queue > 0:
+		return p.queue
+	case n > maxDefaultQueue:
+		return maxDefaultQueue
+	case n > 0:
+		return n
+	default:
+		return 1
+	}
+}
+
+// skippedJobs returns the number of requests skipped as the queue was full
+func (p *workerPool) skippedJobs() int64 {
+	return atomic.LoadInt64(&p.skipped)
+}
+
+// max returns the most workers an automatically sized pool may have, which
+// changes with the target rate, or a pattern's peak rate
+func (p *workerPool) max() int {
+	busy := p.lt.cfg.timeout + p.lt.cfg.thinkTime.max
+	return int(float64(atomic.LoadInt64(&p.lt.rps))*busy.Seconds()) + 1
+}
+
+// workers returns the number of workers in the pool
+func (p *workerPool) workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}